@@ -1,15 +1,20 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"atomicgo.dev/keyboard/keys"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/server"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
@@ -43,6 +48,77 @@ var appsSelectCmd = &cobra.Command{
 	},
 }
 
+var appsEnableCmd = &cobra.Command{
+	Use:   "enable <app config name>",
+	Short: "Enable an app",
+	Long: `Enable a single app, with --for it is disabled automatically after the duration.
+A manifest in the flatpak folder that was not created by browser-glue is only replaced with --force,
+it is backed up and restored when the app is disabled.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := enableApp(selectedBrowserFlag.Browser, args[0], *enableForFlag, *enableForceFlag)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+var appsDisableCmd = &cobra.Command{
+	Use:   "disable <app config name>",
+	Short: "Disable an app",
+	Long:  `Disable a single app.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := disableApp(selectedBrowserFlag.Browser, args[0])
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+var appsConfigureCmd = &cobra.Command{
+	Use:   "configure <app config name>",
+	Short: "Configure an app",
+	Long:  `Change the settings of a single app, without any flags the current settings are printed.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := configureApp(cmd, selectedBrowserFlag.Browser, args[0])
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+var appsApproveCmd = &cobra.Command{
+	Use:   "approve <app config name>",
+	Short: "Approve the executable of an app",
+	Long:  `Pin the current executable of an app, hosts are only started if their executable was approved and did not change since.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := approveApp(selectedBrowserFlag.Browser, args[0])
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+var appsRevokeCmd = &cobra.Command{
+	Use:   "revoke <app config name> [extension]",
+	Short: "Revoke approval decisions",
+	Long:  `Forget the decisions about extensions of an app, so they are asked for approval again. Without an extension all decisions are revoked.`,
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		extension := ""
+		if len(args) == 2 {
+			extension = args[1]
+		}
+		exitCode := revokeDecisions(selectedBrowserFlag.Browser, args[0], extension)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
 func listApps(browser util.Browser) int {
 	if browser == util.NoneBrowser {
 		browserNew, exitCode := askForBrowser()
@@ -57,10 +133,20 @@ func listApps(browser util.Browser) int {
 		return exitCode
 	}
 
-	data := [][]string{{"App Config Name", "Enabled", "Supported Extensions"}}
+	policyErr := settings.SystemPolicyError()
+	if policyErr != nil {
+		pterm.Error.Println(policyErr)
+	}
+
+	data := [][]string{{"App Config Name", "Enabled", "Supported Extensions", "Flatpak Host", "Executable"}}
 
 	for _, configFile := range configFiles {
-		newLine := []string{configFile.Name(), fmt.Sprint(configFile.IsEnabled()), strings.Join(configFile.Content.GetExtensions(), " | ")}
+		executableStatus, _ := configFile.VerifyExecutable()
+		executable := string(executableStatus)
+		if executableStatus == config.ExecutableChanged || executableStatus == config.ExecutableNotPinned {
+			executable = pterm.Red(executable)
+		}
+		newLine := []string{configFile.Name(), enabledDescription(configFile), strings.Join(extensionsDescription(configFile), " | "), valueOrDefault(configFile.FlatpakHostId, "-"), executable}
 		data = append(data, newLine)
 	}
 
@@ -135,6 +221,7 @@ func selectApps(browser util.Browser) int {
 		}
 
 		pterm.Info.Println("App", config.Name(), "enabled.")
+		warnIfExecutableChanged(config, config.Name())
 	}
 	for _, config := range disableConfigs {
 		err = config.Disable()
@@ -153,6 +240,384 @@ func selectApps(browser util.Browser) int {
 	return finalErrCode
 }
 
+func enableApp(browser util.Browser, name string, duration time.Duration, force bool) int {
+	configFile, _, exitCode := findApp(browser, name)
+	if exitCode != 0 {
+		return exitCode
+	}
+	if duration < 0 {
+		pterm.Error.Println("The duration can't be negative:", duration)
+		return 1
+	}
+
+	var err error
+	if force {
+		err = configFile.BackupForeignManifest()
+		if err != nil {
+			pterm.Error.Println("Failed to back up the manifest of", name, ":", err)
+			return 1
+		}
+	}
+	if duration > 0 {
+		err = configFile.EnableFor(duration)
+	} else {
+		err = configFile.Enable()
+	}
+	if errors.Is(err, config.ErrForeignManifest) {
+		pterm.Error.Println("Failed to enable app", name, ":", err)
+		pterm.Info.Println("Use --force to back up the manifest and restore it when the app is disabled.")
+		return 1
+	}
+	if err != nil {
+		pterm.Error.Println("Failed to enable app", name, ":", err)
+		return 1
+	}
+
+	if duration > 0 {
+		pterm.Info.Println("App", name, "enabled until", configFile.EnabledUntil().Format(time.DateTime)+".")
+	} else {
+		pterm.Info.Println("App", name, "enabled.")
+	}
+	warnIfExecutableChanged(configFile, name)
+	pterm.Info.Println("Server will be reloaded automatically if it's running.")
+	return 0
+}
+
+func disableApp(browser util.Browser, name string) int {
+	configFile, _, exitCode := findApp(browser, name)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	err := configFile.Disable()
+	if err != nil {
+		pterm.Error.Println("Failed to disable app", name, ":", err)
+		return 1
+	}
+	pterm.Info.Println("App", name, "disabled.")
+	pterm.Info.Println("Server will be reloaded automatically if it's running.")
+	return 0
+}
+
+func configureApp(cmd *cobra.Command, browser util.Browser, name string) int {
+	configFile, browser, exitCode := findApp(browser, name)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	appSettings := settings.GetAppSettings(browser, configFile.Name())
+
+	changed := false
+	if cmd.Flags().Changed("prewarm") {
+		appSettings.PrewarmHosts = *prewarmHostsFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("prewarm-idle-timeout") {
+		appSettings.PrewarmIdleTimeout = *prewarmIdleTimeoutFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("idle-timeout") {
+		appSettings.IdleTimeout = *idleTimeoutFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("max-lifetime") {
+		appSettings.MaxLifetime = *maxLifetimeFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("max-open-files") {
+		appSettings.MaxOpenFiles = *maxOpenFilesFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("max-memory") {
+		appSettings.MaxMemoryMiB = *maxMemoryFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("cpu-time") {
+		appSettings.CPUTime = *cpuTimeFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("niceness") {
+		appSettings.Niceness = *nicenessFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("max-processes") {
+		appSettings.MaxProcesses = *maxProcessesFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("env") {
+		appSettings.Env = withoutEmpty(*envFlag)
+		changed = true
+	}
+	if cmd.Flags().Changed("clear-env") {
+		appSettings.ClearEnv = *clearEnvFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("arg") {
+		appSettings.ExtraArgs = withoutEmpty(*extraArgsFlag)
+		changed = true
+	}
+	if cmd.Flags().Changed("working-dir") {
+		appSettings.WorkingDir = *workingDirFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("wrapper") {
+		appSettings.Wrapper = *wrapperFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("container") {
+		appSettings.Container = *containerFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("peer-verification") {
+		appSettings.PeerVerification = *peerVerificationFlag
+		changed = true
+	}
+	if cmd.Flags().Changed("allow-extension") {
+		appSettings.AllowedExtensions = withoutEmpty(*allowedExtensionsFlag)
+		changed = true
+	}
+	if cmd.Flags().Changed("block-extension") {
+		appSettings.BlockedExtensions = withoutEmpty(*blockedExtensionsFlag)
+		changed = true
+	}
+
+	if !changed {
+		printAppSettings(browser, appSettings)
+		return 0
+	}
+
+	err := settings.SetAppSettings(browser, appSettings)
+	if err != nil {
+		pterm.Error.Println("Failed to save the settings of", name, ":", err)
+		return 1
+	}
+
+	pterm.Info.Println("Settings of", name, "saved, they apply to the next connection of a running server.")
+
+	// rewrites the manifest in the flatpak folder, so it only lists the allowed extensions
+	configFile.IsEnabled()
+
+	err = server.CheckHostExecutable(configFile, appSettings)
+	if err != nil {
+		pterm.Warning.Println("The host of", name, "can't be started:", err)
+	}
+	return 0
+}
+
+// warnIfExecutableChanged reports an executable that doesn't match its pin, enabling an app keeps the pin.
+func warnIfExecutableChanged(configFile config.NativeConfigFile, name string) {
+	status, err := configFile.VerifyExecutable()
+	if status != config.ExecutableChanged {
+		return
+	}
+	pterm.Warning.Println(err)
+	pterm.Info.Println("The host of", name, "is not started until you approve the executable again with \"browser-glue apps approve "+name+"\".")
+}
+
+func approveApp(browser util.Browser, name string) int {
+	configFile, browser, exitCode := findApp(browser, name)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	status, err := configFile.VerifyExecutable()
+	switch status {
+	case config.ExecutableApproved:
+		pterm.Info.Println("The executable of", name, "is already approved.")
+		return 0
+	case config.ExecutableUnverifiable:
+		pterm.Info.Println("The executable of", name, "is not on this system and can't be pinned.")
+		return 0
+	case config.ExecutableChanged:
+		pterm.Warning.Println(err)
+	}
+
+	err = configFile.PinExecutable()
+	if err != nil {
+		pterm.Error.Println("Failed to approve the executable of", name, ":", err)
+		return 1
+	}
+	pterm.Info.Println("Executable", configFile.Content.Executable, "of", name, "approved.")
+	return 0
+}
+
+func revokeDecisions(browser util.Browser, name string, extension string) int {
+	configFile, browser, exitCode := findApp(browser, name)
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	appSettings := settings.GetAppSettings(browser, configFile.Name())
+	if extension == "" {
+		appSettings.ExtensionDecisions = nil
+	} else {
+		if appSettings.GetExtensionDecision(extension) == "" {
+			pterm.Error.Println("There is no decision about", extension, "for", name)
+			return 1
+		}
+		appSettings.SetExtensionDecision(extension, "")
+	}
+
+	err := settings.SetAppSettings(browser, appSettings)
+	if err != nil {
+		pterm.Error.Println("Failed to save settings of", name, ":", err)
+		return 1
+	}
+	pterm.Info.Println("Decisions revoked, the extensions are asked for approval again the next time they connect.")
+	return 0
+}
+
+func printAppSettings(browser util.Browser, appSettings settings.AppSettings) {
+	lockedKeys := settings.LockedAppSettings(browser, appSettings.Name)
+	row := func(key string, name string, value string) []string {
+		if slices.Contains(lockedKeys, key) {
+			name += " (locked)"
+		}
+		return []string{name, value}
+	}
+
+	data := [][]string{
+		{"Setting", "Value"},
+		row("prewarmHosts", "Prewarmed hosts", fmt.Sprint(appSettings.PrewarmHosts)),
+		row("prewarmIdleTimeout", "Prewarm idle timeout", appSettings.GetPrewarmIdleTimeout().String()),
+		row("idleTimeout", "Idle timeout", durationOrNever(appSettings.GetIdleTimeout())),
+		row("maxLifetime", "Maximum lifetime", durationOrNever(appSettings.GetMaxLifetime())),
+		row("maxOpenFiles", "Maximum open files", numberOrUnlimited(appSettings.MaxOpenFiles, "")),
+		row("maxMemoryMiB", "Maximum memory", numberOrUnlimited(appSettings.MaxMemoryMiB, " MiB")),
+		row("cpuTime", "CPU time", durationOrUnlimited(appSettings.GetCPUTime())),
+		row("niceness", "Niceness", fmt.Sprint(appSettings.Niceness)),
+		row("maxProcesses", "Maximum processes", numberOrUnlimited(appSettings.MaxProcesses, "")),
+		row("env", "Environment", listOrNone(appSettings.Env)),
+		row("clearEnv", "Clear environment", fmt.Sprint(appSettings.ClearEnv)),
+		row("extraArgs", "Extra arguments", listOrNone(appSettings.ExtraArgs)),
+		row("workingDir", "Working directory", valueOrDefault(appSettings.WorkingDir, "folder of the executable")),
+		row("wrapper", "Wrapper", valueOrDefault(appSettings.GetWrapperTemplate(), "none")),
+		row("container", "Container", valueOrDefault(appSettings.Container, "none")),
+		row("peerVerification", "Peer verification", valueOrDefault(appSettings.PeerVerification, settings.PeerVerificationPermissive)),
+		row("allowedExtensions", "Allowed extensions", valueOrDefault(strings.Join(appSettings.AllowedExtensions, "\n"), "all")),
+		row("blockedExtensions", "Blocked extensions", listOrNone(appSettings.BlockedExtensions)),
+		row("extensionDecisions", "Approved extensions", listOrNone(decidedExtensions(appSettings, settings.DecisionAllow))),
+		row("extensionDecisions", "Denied extensions", listOrNone(decidedExtensions(appSettings, settings.DecisionDeny))),
+	}
+
+	pterm.DefaultTable.
+		WithHasHeader(true).
+		WithHeaderRowSeparator("-").
+		WithData(data).
+		Render()
+}
+
+// extensionsDescription marks the extensions of the manifest that are not allowed by the settings.
+func extensionsDescription(configFile config.NativeConfigFile) []string {
+	enabledExtensions := configFile.EnabledExtensions()
+	extensions := []string{}
+	for _, extension := range configFile.Content.GetExtensions() {
+		if !slices.Contains(enabledExtensions, extension) {
+			extension += " (blocked)"
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions
+}
+
+// enabledDescription includes the remaining time of apps that were enabled for a limited time.
+func enabledDescription(configFile config.NativeConfigFile) string {
+	enabled := configFile.IsEnabled()
+	if settings.SystemPolicyError() != nil {
+		return "false (system policy invalid)"
+	}
+	switch configFile.PolicyState() {
+	case settings.HostForced:
+		return "true (forced by policy)"
+	case settings.HostForbidden:
+		return "false (forbidden by policy)"
+	}
+	if enabled && configFile.HasForeignManifest() {
+		return "conflict (foreign manifest)"
+	}
+	enabledUntil := configFile.EnabledUntil()
+	if !enabled || enabledUntil.IsZero() {
+		return fmt.Sprint(enabled)
+	}
+	remaining := time.Until(enabledUntil)
+	if remaining <= 0 {
+		return "true (expired)"
+	}
+	return fmt.Sprintf("true (%s left)", remaining.Round(time.Second))
+}
+
+func decidedExtensions(appSettings settings.AppSettings, decision string) []string {
+	extensions := []string{}
+	for _, extensionDecision := range appSettings.ExtensionDecisions {
+		if extensionDecision.Decision == decision {
+			extensions = append(extensions, extensionDecision.Extension)
+		}
+	}
+	return extensions
+}
+
+func durationOrNever(duration time.Duration) string {
+	if duration == 0 {
+		return "never"
+	}
+	return duration.String()
+}
+
+func durationOrUnlimited(duration time.Duration) string {
+	if duration == 0 {
+		return "unlimited"
+	}
+	return duration.String()
+}
+
+func numberOrUnlimited(number int, unit string) string {
+	if number == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(number, unit)
+}
+
+func listOrNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+	return strings.Join(list, "\n")
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// withoutEmpty allows clearing a list with an empty flag like --env=
+func withoutEmpty(list []string) []string {
+	return slices.DeleteFunc(slices.Clone(list), func(element string) bool { return element == "" })
+}
+
+// findApp asks for the browser if none was selected.
+func findApp(browser util.Browser, name string) (config.NativeConfigFile, util.Browser, int) {
+	if browser == util.NoneBrowser {
+		browserNew, exitCode := askForBrowser()
+		if exitCode != 0 {
+			return config.NativeConfigFile{}, browser, exitCode
+		}
+		browser = browserNew
+	}
+
+	configFiles, configFileNames, _, exitCode := collectConfigFiles(browser)
+	if exitCode != 0 {
+		return config.NativeConfigFile{}, browser, exitCode
+	}
+	index := slices.IndexFunc(configFiles, func(configFile config.NativeConfigFile) bool { return configFile.Name() == name })
+	if index == -1 {
+		pterm.Error.Println("Could not find the app", name, "available apps are:", strings.Join(configFileNames, ", "))
+		return config.NativeConfigFile{}, browser, 1
+	}
+	return configFiles[index], browser, 0
+}
+
 func collectConfigFiles(browser util.Browser) ([]config.NativeConfigFile, []string, []string, int) {
 	configFiles, err := config.CollectConfigFiles(browser)
 	if err != nil {
@@ -180,4 +645,51 @@ func collectConfigFiles(browser util.Browser) ([]config.NativeConfigFile, []stri
 func init() {
 	appsCmd.AddCommand(appsListCmd)
 	appsCmd.AddCommand(appsSelectCmd)
+	appsCmd.AddCommand(appsEnableCmd)
+	appsCmd.AddCommand(appsDisableCmd)
+	appsCmd.AddCommand(appsConfigureCmd)
+	appsCmd.AddCommand(appsApproveCmd)
+	appsCmd.AddCommand(appsRevokeCmd)
+
+	enableForFlag = appsEnableCmd.Flags().Duration("for", 0, "disable the app automatically after this duration, e.g. 2h")
+	enableForceFlag = appsEnableCmd.Flags().Bool("force", false, "back up a manifest that was not created by browser-glue and replace it")
+	prewarmHostsFlag = appsConfigureCmd.Flags().Int("prewarm", 0, "number of host processes to start before the extension connects, 0 disables it")
+	prewarmIdleTimeoutFlag = appsConfigureCmd.Flags().String("prewarm-idle-timeout", "", "stop prewarmed hosts that were not used for this long, e.g. 5m")
+	idleTimeoutFlag = appsConfigureCmd.Flags().String("idle-timeout", "", "close connections without any messages for this long, empty disables it")
+	maxLifetimeFlag = appsConfigureCmd.Flags().String("max-lifetime", "", "close connections that are open for longer than this, empty disables it")
+	maxOpenFilesFlag = appsConfigureCmd.Flags().Int("max-open-files", 0, "maximum number of files a host can open, 0 is unlimited")
+	maxMemoryFlag = appsConfigureCmd.Flags().Int("max-memory", 0, "maximum address space of a host in MiB, 0 is unlimited")
+	cpuTimeFlag = appsConfigureCmd.Flags().String("cpu-time", "", "maximum CPU time of a host, e.g. 30s, empty is unlimited")
+	nicenessFlag = appsConfigureCmd.Flags().Int("niceness", 0, "scheduling priority of hosts from 0 to 19, higher is lower priority")
+	maxProcessesFlag = appsConfigureCmd.Flags().Int("max-processes", 0, "maximum number of hosts running at the same time per extension, 0 is unlimited")
+	envFlag = appsConfigureCmd.Flags().StringArray("env", []string{}, "environment variable KEY=VALUE for hosts, can be repeated, replaces the current list, --env= clears it")
+	clearEnvFlag = appsConfigureCmd.Flags().Bool("clear-env", false, "start hosts only with the variables set with --env")
+	extraArgsFlag = appsConfigureCmd.Flags().StringArray("arg", []string{}, "argument appended to the arguments of the browser, can be repeated, replaces the current list, --arg= clears it")
+	workingDirFlag = appsConfigureCmd.Flags().String("working-dir", "", "absolute path of the working directory of hosts, empty uses the folder of the executable")
+	wrapperFlag = appsConfigureCmd.Flags().String("wrapper", "", "start hosts through a command like \"distrobox-enter -n dev -- {exec} {args}\" or one of the presets "+strings.Join(slices.Sorted(maps.Keys(settings.WrapperPresets)), ", "))
+	containerFlag = appsConfigureCmd.Flags().String("container", "", "container used for {container} in the wrapper")
+	allowedExtensionsFlag = appsConfigureCmd.Flags().StringArray("allow-extension", []string{}, "only allow the listed extensions that match an ID, a glob like \"*@example.org\" or a regular expression starting with \"re:\", can be repeated, replaces the current list, --allow-extension= allows all")
+	blockedExtensionsFlag = appsConfigureCmd.Flags().StringArray("block-extension", []string{}, "block extensions matching an ID, glob or regular expression even if they are allowed, can be repeated, replaces the current list, --block-extension= clears it")
+	peerVerificationFlag = appsConfigureCmd.Flags().String("peer-verification", "", "\""+settings.PeerVerificationStrict+"\" rejects clients that don't run inside the flatpak of the browser, \""+settings.PeerVerificationPermissive+"\" only logs them")
 }
+
+var enableForFlag *time.Duration
+var enableForceFlag *bool
+var prewarmHostsFlag *int
+var prewarmIdleTimeoutFlag *string
+var idleTimeoutFlag *string
+var maxLifetimeFlag *string
+var maxOpenFilesFlag *int
+var maxMemoryFlag *int
+var cpuTimeFlag *string
+var nicenessFlag *int
+var maxProcessesFlag *int
+var envFlag *[]string
+var clearEnvFlag *bool
+var extraArgsFlag *[]string
+var workingDirFlag *string
+var wrapperFlag *string
+var containerFlag *string
+var peerVerificationFlag *string
+var allowedExtensionsFlag *[]string
+var blockedExtensionsFlag *[]string
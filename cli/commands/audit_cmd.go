@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/taukakao/browser-glue/lib/audit"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show launched hosts",
+	Long: `Print the audit log of the hosts the server launched, oldest first.
+Every launch has a record from before the host started and one from when it ended.
+--since and --until take a duration like 2h, which is counted back from now, or a time like "2006-01-02 15:04:05".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := showAuditLog(selectedBrowserFlag.Browser)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+func showAuditLog(browser util.Browser) int {
+	filter := audit.Filter{App: *auditAppFlag, Extension: *auditExtensionFlag}
+	if browser != util.NoneBrowser {
+		filter.Browser = string(browser)
+	}
+
+	var err error
+	filter.Since, err = parseAuditTime(*auditSinceFlag)
+	if err != nil {
+		pterm.Error.Println("Invalid --since:", err)
+		return 1
+	}
+	filter.Until, err = parseAuditTime(*auditUntilFlag)
+	if err != nil {
+		pterm.Error.Println("Invalid --until:", err)
+		return 1
+	}
+
+	records, err := audit.Query(filter)
+	if err != nil {
+		pterm.Error.Println("Could not read the audit log:", err)
+		return 1
+	}
+
+	if *auditJSONFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			encoder.Encode(record)
+		}
+		return 0
+	}
+
+	if len(records) == 0 {
+		pterm.Info.Println("No launches found in", audit.GetLogPath())
+		return 0
+	}
+
+	data := [][]string{{"Time", "Event", "Browser", "Extension", "App", "Executable", "SHA256", "Peer PID", "Duration", "Exit Status", "Reason"}}
+	for _, record := range records {
+		duration := "-"
+		if record.EventName() == audit.EventEnded {
+			duration = record.Duration().Round(time.Millisecond).String()
+		}
+		data = append(data, []string{
+			record.Time.Local().Format(time.DateTime),
+			record.EventName(),
+			record.Browser,
+			record.Extension,
+			record.App,
+			record.Executable,
+			valueOrDefault(shortChecksum(record.SHA256), "-"),
+			valueOrDefault(pidOrEmpty(record.PeerPID), "-"),
+			duration,
+			valueOrDefault(record.ExitStatus, "-"),
+			valueOrDefault(record.Reason, "-"),
+		})
+	}
+
+	pterm.DefaultTable.
+		WithHasHeader(true).
+		WithHeaderRowSeparator("-").
+		WithData(data).
+		Render()
+	return 0
+}
+
+// parseAuditTime accepts a duration before now or an absolute time, an empty value is the zero time.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		parsed, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a time", value)
+}
+
+// shortChecksum keeps enough of a checksum to tell executables apart, like git does with commits.
+func shortChecksum(checksum string) string {
+	if len(checksum) != 64 {
+		return checksum
+	}
+	return checksum[:12]
+}
+
+func pidOrEmpty(pid int) string {
+	if pid == 0 {
+		return ""
+	}
+	return strconv.Itoa(pid)
+}
+
+var auditSinceFlag *string
+var auditUntilFlag *string
+var auditAppFlag *string
+var auditExtensionFlag *string
+var auditJSONFlag *bool
+
+func init() {
+	auditSinceFlag = auditCmd.Flags().String("since", "", "only show launches after this time or duration ago")
+	auditUntilFlag = auditCmd.Flags().String("until", "", "only show launches before this time or duration ago")
+	auditAppFlag = auditCmd.Flags().String("app", "", "only show launches of the app config or host with this name")
+	auditExtensionFlag = auditCmd.Flags().String("extension", "", "only show launches for this extension")
+	auditJSONFlag = auditCmd.Flags().Bool("json", false, "print the records as JSON lines")
+}
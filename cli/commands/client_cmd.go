@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/taukakao/browser-glue/lib/server"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Inspect the client",
+	Long:  `Inspect the client that browsers start from inside their sandbox.`,
+}
+
+var clientLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show client logs",
+	Long:  `Print the diagnostics the client writes while connecting browsers to apps.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := showClientLogs(selectedBrowserFlag.Browser, *clientLogLines)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+var clientStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show client versions",
+	Long:  `Print which client version is deployed for each browser and if it is outdated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitCode := showClientStatus(selectedBrowserFlag.Browser)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+func showClientStatus(browser util.Browser) int {
+	browsers := util.GetAllBrowsers()
+	if browser != util.NoneBrowser {
+		browsers = []util.Browser{browser}
+	}
+
+	embeddedInfo := server.GetEmbeddedClientInfo()
+	pterm.Info.Println("Current client version", embeddedInfo.Version, "with checksum", embeddedInfo.Checksum)
+
+	exitCode := 0
+	data := [][]string{{"Browser", "Status", "Version", "Checksum"}}
+
+	for _, browser := range browsers {
+		deployedInfo, err := server.GetDeployedClientInfo(browser)
+		if errors.Is(err, os.ErrNotExist) {
+			data = append(data, []string{browser.GetName(), "not deployed", "", ""})
+			continue
+		}
+		if err != nil {
+			pterm.Error.Println("Could not check client of", browser.GetName(), ":", err)
+			exitCode = 1
+			continue
+		}
+
+		status := "up to date"
+		if deployedInfo.Checksum != embeddedInfo.Checksum {
+			status = "outdated"
+		}
+		data = append(data, []string{browser.GetName(), status, deployedInfo.Version, deployedInfo.Checksum})
+	}
+
+	pterm.DefaultTable.
+		WithHasHeader(true).
+		WithRowSeparator("-").
+		WithHeaderRowSeparator("-").
+		WithData(data).
+		Render()
+
+	return exitCode
+}
+
+func showClientLogs(browser util.Browser, maxLines int) int {
+	browsers := util.GetAllBrowsers()
+	if browser != util.NoneBrowser {
+		browsers = []util.Browser{browser}
+	}
+
+	for _, browser := range browsers {
+		lines, err := readClientLogLines(browser)
+		if err != nil {
+			pterm.Error.Println("Could not read client log of", browser.GetName(), ":", err)
+			return 1
+		}
+		if len(lines) == 0 {
+			pterm.Info.Println("No client logs for", browser.GetName())
+			continue
+		}
+		if maxLines > 0 && len(lines) > maxLines {
+			lines = lines[len(lines)-maxLines:]
+		}
+
+		pterm.DefaultSection.Println(browser.GetName())
+		fmt.Println(strings.Join(lines, "\n"))
+	}
+
+	return 0
+}
+
+func readClientLogLines(browser util.Browser) ([]string, error) {
+	lines := []string{}
+	for _, logPath := range []string{browser.GetRotatedClientLogPath(), browser.GetClientLogPath()} {
+		data, err := os.ReadFile(logPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return lines, err
+		}
+		content := strings.TrimSuffix(string(data), "\n")
+		if content == "" {
+			continue
+		}
+		lines = append(lines, strings.Split(content, "\n")...)
+	}
+	return lines, nil
+}
+
+var clientLogLines *int
+
+func init() {
+	clientLogLines = clientLogsCmd.Flags().IntP("lines", "n", 50, "number of lines to show per browser, 0 shows everything")
+
+	clientCmd.AddCommand(clientLogsCmd)
+	clientCmd.AddCommand(clientStatusCmd)
+}
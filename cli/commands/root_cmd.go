@@ -10,6 +10,8 @@ import (
 
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
@@ -21,6 +23,35 @@ var rootCmd = &cobra.Command{
 	Use:   filepath.Base(os.Args[0]),
 	Short: "Command to connect browser extensions with applications.",
 	Long:  `Browser Glue is an application that allows users to connect their browser extensions to locally running applications.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		configureLogging(cmd)
+	},
+}
+
+// configureLogging applies the [logging] settings, flags override them.
+func configureLogging(cmd *cobra.Command) {
+	config := settings.LoggingConfig()
+	if cmd.Flags().Changed("log-format") {
+		config.Format = *logFormatFlag
+	}
+	if cmd.Flags().Changed("log-level") {
+		level, err := logs.ParseLogLevel(*logLevelFlag)
+		if err != nil {
+			pterm.Warning.Println("Ignoring --log-level:", err)
+		}
+		config.Level = level
+	}
+	if cmd.Flags().Changed("log-file") {
+		config.File = *logFileFlag
+	}
+	if cmd.Flags().Changed("color") {
+		config.Color = *colorFlag
+	}
+
+	err := logs.Configure(config)
+	if err != nil {
+		pterm.Warning.Println("Could not configure logging:", err)
+	}
 }
 
 func askForBrowser() (util.Browser, int) {
@@ -71,10 +102,20 @@ func (bv *BrowserValue) Type() string {
 }
 
 var selectedBrowserFlag BrowserValue
+var logFormatFlag *string
+var logLevelFlag *string
+var logFileFlag *bool
+var colorFlag *string
 
 func init() {
 	rootCmd.PersistentFlags().VarP(&selectedBrowserFlag, "browser", "b", "select browser")
+	logFormatFlag = rootCmd.PersistentFlags().String("log-format", "", "format of log messages: "+logs.FormatText+", "+logs.FormatJSON+" or "+logs.FormatJournald+", the default is "+logs.FormatJournald+" when running as a systemd service")
+	logLevelFlag = rootCmd.PersistentFlags().String("log-level", "", "lowest level of log messages that are shown: debug, info, warn or error")
+	logFileFlag = rootCmd.PersistentFlags().Bool("log-file", false, "also write log messages to "+util.MakePathHomeRelative(logs.GetLogFilePath()))
+	colorFlag = rootCmd.PersistentFlags().String("color", logs.ColorAuto, "use colors: "+logs.ColorAuto+", "+logs.ColorAlways+" or "+logs.ColorNever)
 
 	rootCmd.AddCommand(appsCmd)
 	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(clientCmd)
+	rootCmd.AddCommand(auditCmd)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 	"github.com/taukakao/browser-glue/lib/server"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
@@ -30,7 +31,18 @@ func startServer() int {
 	}
 
 	allServersExited := make(chan struct{})
-	server.RunEnabledServersBackground(browser, *listenIn, allServersExited)
+	multiplex := *multiplexSockets || settings.MultiplexSocketsEnabled()
+	if settings.IsServerSettingLocked("multiplexSockets") {
+		if *multiplexSockets && !settings.MultiplexSocketsEnabled() {
+			pterm.Warning.Println("Multiplexing sockets is disabled by the system policy")
+		}
+		multiplex = settings.MultiplexSocketsEnabled()
+	}
+	if *listenIn && settings.ListenInDisabledByPolicy() {
+		pterm.Warning.Println("Listening in is disabled by the system policy")
+	}
+
+	server.RunEnabledServersBackground(browser, *listenIn, multiplex, allServersExited)
 
 	pterm.Info.Println("Servers started")
 
@@ -55,7 +67,9 @@ func startServer() int {
 }
 
 var listenIn *bool
+var multiplexSockets *bool
 
 func init() {
 	listenIn = serverCmd.PersistentFlags().BoolP("listen-in", "l", false, "print out messages that are sent through this program")
+	multiplexSockets = serverCmd.PersistentFlags().BoolP("multiplex", "m", false, "use a single socket per browser for all apps, can also be enabled with server.multiplexSockets in the settings")
 }
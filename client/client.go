@@ -1,12 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/taukakao/browser-glue/lib/protocol"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
@@ -14,51 +19,184 @@ func printSimpleError(v ...any) {
 	fmt.Fprintln(os.Stderr, v...)
 }
 
+// exitWithError tells the extension why the host is not available before exiting,
+// because the browser only reports that the native host has exited.
+func exitWithError(message string, err error) {
+	if err != nil {
+		printSimpleError(message+":", err)
+		diagnostics.Println("exit:", message+":", err)
+	} else {
+		printSimpleError(message)
+		diagnostics.Println("exit:", message)
+	}
+	diagnostics.Close()
+	protocol.WriteFrame(os.Stdout, protocol.ErrorMessage{Error: message})
+	os.Exit(1)
+}
+
+var diagnostics *clientLog
+
 func main() {
 	var err error
 
-	isChrome := len(os.Args) == 2
-	isFirefox := len(os.Args) == 3
+	exePath, err := os.Executable()
+	if err != nil {
+		exitWithError("browser-glue can't determine the path of its client", err)
+	}
+	runtimeAppFolder := filepath.Dir(exePath)
 
-	var extensionName string
-	if isFirefox {
-		extensionName = os.Args[2]
-	} else if isChrome {
-		extensionName = os.Args[1]
-	} else {
-		printSimpleError("unsupported browser")
-		os.Exit(1)
+	if len(os.Args) == 2 && os.Args[1] == "--diagnose" {
+		os.Exit(diagnose(runtimeAppFolder))
 	}
 
-	exePath, err := os.Executable()
+	diagnostics = openClientLog(runtimeAppFolder)
+	defer diagnostics.Close()
+	diagnostics.Println("start: version", util.GetVersion(), "called as", os.Args[0], "with args", os.Args[1:])
+
+	browserArgs, err := util.ParseBrowserArguments(os.Args[1:])
 	if err != nil {
-		printSimpleError("can't determine path of executable:", err)
+		exitWithError("browser-glue does not support the arguments of this browser", err)
 	}
 
-	socketFileName := util.GenerateSocketFileName(extensionName)
+	hostName, ok := findHostName(browserArgs.ManifestPath)
+	if !ok {
+		exitWithError("browser-glue can't determine which native host was requested", nil)
+	}
 
-	socketPath := filepath.Join(filepath.Dir(exePath), socketFileName)
+	socketFolder := util.GetSocketFolder(runtimeAppFolder)
+	socketPath := filepath.Join(socketFolder, util.GenerateSocketFileName(hostName, browserArgs.Extension))
+	multiplexSocketPath := filepath.Join(socketFolder, protocol.MultiplexSocketName)
 
-	conn, err := net.Dial("unix", socketPath)
+	dialStart := time.Now()
+	conn, err := dialWithRetries([]string{socketPath, multiplexSocketPath}, connectTimeout())
 	if err != nil {
-		printSimpleError("failed to dial unix socket", err)
-		os.Exit(1)
-		return
+		exitWithError("browser-glue server not running", err)
 	}
 	defer conn.Close()
+	diagnostics.Println("dial: connected to", hostName, "for", browserArgs.Extension, "after", time.Since(dialStart).Round(time.Millisecond))
+
+	err = handshake(conn, hostName, browserArgs)
+	if err != nil {
+		exitWithError("browser-glue server refused the connection: "+err.Error(), nil)
+	}
+
+	copyEvent := make(chan copyResult)
+
+	go copyWithErr(conn, os.Stdin, "sent", copyEvent)
+	go copyWithErr(os.Stdout, conn, "received", copyEvent)
 
-	errEvent := make(chan error)
+	result := <-copyEvent
+	exitReason := "end of stream"
+	if result.err != nil {
+		printSimpleError("writing to socket failed", result.err)
+		exitReason = result.err.Error()
+	}
+	diagnostics.Println("exit:", exitReason, "in direction", result.direction, "sent", sentBytes.Load(), "bytes received", receivedBytes.Load(), "bytes")
+}
+
+// dialWithRetries tries the sockets in order and keeps trying while the server might be restarting.
+func dialWithRetries(socketPaths []string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := 50 * time.Millisecond
 
-	go copyWithErr(conn, os.Stdin, errEvent)
-	go copyWithErr(os.Stdout, conn, errEvent)
+	for {
+		var firstErr error
+		for _, socketPath := range socketPaths {
+			conn, err := net.Dial("unix", socketPath)
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, firstErr
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Second)
+	}
+}
 
-	err = <-errEvent
+// connectTimeout can be changed with flatpak override --env=BROWSER_GLUE_CONNECT_TIMEOUT=10s <browser>
+func connectTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("BROWSER_GLUE_CONNECT_TIMEOUT"))
+	if err != nil || timeout < 0 {
+		return defaultConnectTimeout
+	}
+	return timeout
+}
+
+const defaultConnectTimeout = 3 * time.Second
+
+func handshake(conn net.Conn, hostName string, browserArgs util.BrowserArguments) error {
+	hello := protocol.ClientHello{
+		ProtocolVersion: protocol.Version,
+		ClientVersion:   util.GetVersion(),
+		Browser:         string(browserArgs.Flavour),
+		HostName:        hostName,
+		Extension:       browserArgs.Extension,
+		ManifestPath:    browserArgs.ManifestPath,
+		PID:             os.Getpid(),
+		Args:            os.Args[1:],
+	}
+
+	// a server that stalls would otherwise keep the browser waiting forever
+	conn.SetDeadline(time.Now().Add(protocol.ServerHelloTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	err := protocol.WriteFrame(conn, hello)
+	if err != nil {
+		return err
+	}
+
+	serverHello := protocol.ServerHello{}
+	err = protocol.ReadFrame(conn, &serverHello)
 	if err != nil {
-		printSimpleError("writing to socket failed", err)
+		return err
+	}
+	if serverHello.Error != "" {
+		return errors.New(serverHello.Error)
 	}
+	return nil
+}
+
+// findHostName uses the name the client was called with and falls back to the manifest path firefox passes.
+func findHostName(manifestPath string) (string, bool) {
+	hostName, ok := util.GetHostNameFromClientPath(os.Args[0])
+	if ok {
+		return hostName, true
+	}
+	if manifestPath == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(filepath.Base(manifestPath), ".json"), true
+}
+
+type copyResult struct {
+	direction string
+	err       error
+}
+
+var sentBytes, receivedBytes atomic.Int64
+
+func copyWithErr(dst io.Writer, src io.Reader, direction string, copyEvent chan copyResult) {
+	counter := &sentBytes
+	if direction == "received" {
+		counter = &receivedBytes
+	}
+	_, err := io.Copy(&countingWriter{writer: dst, counter: counter}, src)
+	copyEvent <- copyResult{direction: direction, err: err}
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	counter *atomic.Int64
 }
 
-func copyWithErr(dst io.Writer, src io.Reader, errEvent chan error) {
-	_, err := io.Copy(dst, src)
-	errEvent <- err
+func (countingWriter *countingWriter) Write(p []byte) (int, error) {
+	n, err := countingWriter.writer.Write(p)
+	countingWriter.counter.Add(int64(n))
+	return n, err
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/protocol"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// diagnose checks the sandbox side of the setup without needing a browser extension.
+// Run it from inside the browser sandbox, for example with flatpak run --command=sh org.mozilla.firefox
+func diagnose(runtimeAppFolder string) int {
+	fmt.Println("client version:", util.GetVersion())
+	fmt.Println("protocol version:", protocol.Version)
+	fmt.Println("runtime folder:", runtimeAppFolder)
+	fmt.Println()
+
+	exitCode := 0
+
+	hostEntries, err := os.ReadDir(util.GetHostsFolder(runtimeAppFolder))
+	if err != nil {
+		fmt.Println("can't list hosts:", err)
+		exitCode = 1
+	} else {
+		fmt.Println("hosts:")
+		for _, hostEntry := range hostEntries {
+			fmt.Println("  ", hostEntry.Name())
+		}
+		fmt.Println()
+	}
+
+	socketFolder := util.GetSocketFolder(runtimeAppFolder)
+	socketEntries, err := os.ReadDir(socketFolder)
+	if err != nil {
+		fmt.Println("can't list sockets:", err)
+		return 1
+	}
+
+	fmt.Println("sockets:")
+	for _, socketEntry := range socketEntries {
+		fmt.Println("  ", socketEntry.Name())
+
+		err = diagnoseSocket(filepath.Join(socketFolder, socketEntry.Name()))
+		if err != nil {
+			fmt.Println("      error:", err)
+			exitCode = 1
+		}
+	}
+
+	return exitCode
+}
+
+func diagnoseSocket(socketPath string) error {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		fmt.Println("      accepts connections: no")
+		return err
+	}
+	defer conn.Close()
+	fmt.Println("      accepts connections: yes")
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(5 * time.Second))
+
+	err = protocol.WriteFrame(conn, protocol.ClientHello{
+		ProtocolVersion: protocol.Version,
+		Kind:            protocol.PingKind,
+		ClientVersion:   util.GetVersion(),
+		PID:             os.Getpid(),
+	})
+	if err != nil {
+		return fmt.Errorf("sending ping failed: %w", err)
+	}
+
+	serverHello := protocol.ServerHello{}
+	err = protocol.ReadFrame(conn, &serverHello)
+	if err != nil {
+		return fmt.Errorf("receiving ping answer failed: %w", err)
+	}
+	if serverHello.Error != "" {
+		return errors.New(serverHello.Error)
+	}
+
+	fmt.Println("      ping:", time.Since(start).Round(time.Microsecond))
+	fmt.Println("      server version:", serverHello.ServerVersion)
+	if serverHello.HostName == "" {
+		fmt.Println("      host: all hosts of this browser")
+		return nil
+	}
+	fmt.Println("      host:", serverHello.HostName)
+	fmt.Println("      extension:", serverHello.Extension)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// clientLog appends short diagnostics to a file in the runtime app folder,
+// because browsers usually discard what native hosts print to stderr.
+type clientLog struct {
+	file *os.File
+}
+
+func openClientLog(runtimeAppFolder string) *clientLog {
+	logPath := util.GetClientLogPath(runtimeAppFolder)
+
+	info, err := os.Stat(logPath)
+	if err == nil && info.Size() >= util.MaxClientLogSize {
+		// other clients might rotate at the same time, losing one of the old files is fine
+		os.Rename(logPath, util.GetRotatedClientLogPath(runtimeAppFolder))
+	}
+
+	file, err := util.OpenAppend(logPath, 0o600)
+	if err != nil {
+		printSimpleError("can't open client log:", err)
+		return &clientLog{}
+	}
+	return &clientLog{file: file}
+}
+
+func (log *clientLog) Println(v ...any) {
+	if log == nil || log.file == nil {
+		return
+	}
+	message := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	// a single write per line keeps lines of concurrent clients apart
+	fmt.Fprintf(log.file, "%s pid=%d %s\n", time.Now().Format(time.RFC3339), os.Getpid(), message)
+}
+
+func (log *clientLog) Close() {
+	if log == nil || log.file == nil {
+		return
+	}
+	log.file.Close()
+}
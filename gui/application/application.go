@@ -12,10 +12,17 @@ import (
 	"github.com/taukakao/browser-glue/gui/userapp_settings"
 	"github.com/taukakao/browser-glue/gui/userapps"
 	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
 func RunApplication() {
+	err := logs.Configure(settings.LoggingConfig())
+	if err != nil {
+		logs.Warn("could not configure logging:", err)
+	}
+
 	app := adw.NewApplication(util.GetLongAppId(), gio.ApplicationFlagsNone)
 	app.ConnectActivate(func() { activate(app) })
 
@@ -44,6 +51,8 @@ var activated = make(chan struct{}, 1)
 func activate(app *adw.Application) {
 	activated <- struct{}{}
 
+	servePrompts(app)
+
 	builder := gtk.NewBuilderFromResource("/net/taukakao/BrowserGlue/generated/application/main.ui")
 
 	window := builder.GetObject("main_window").Cast().(*adw.ApplicationWindow)
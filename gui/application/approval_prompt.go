@@ -0,0 +1,77 @@
+package application
+
+import (
+	"fmt"
+
+	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/taukakao/browser-glue/lib/approval"
+	"github.com/taukakao/browser-glue/lib/logs"
+)
+
+// servePrompts shows approval prompts of the server as dialogs while the GUI is running.
+func servePrompts(app *adw.Application) {
+	listener, err := approval.ServePrompts(dialogPrompter{app: app})
+	if err != nil {
+		logs.Warn("approval prompts will not be shown in the GUI", err)
+		return
+	}
+	app.ConnectShutdown(func() { listener.Close() })
+}
+
+type dialogPrompter struct {
+	app *adw.Application
+}
+
+func (prompter dialogPrompter) Prompt(request approval.Request, canceled <-chan struct{}) approval.Decision {
+	answer := make(chan approval.Decision, 1)
+	var dialog *adw.AlertDialog
+
+	glib.IdleAdd(func() {
+		dialog = adw.NewAlertDialog("Approve native app", request.Question())
+		dialog.AddResponse(string(approval.Deny), "Deny")
+		dialog.AddResponse(string(approval.AllowOnce), "Allow Once")
+		dialog.AddResponse(string(approval.AllowAlways), "Always Allow")
+		dialog.SetResponseAppearance(string(approval.Deny), adw.ResponseDestructive)
+		dialog.SetResponseAppearance(string(approval.AllowAlways), adw.ResponseSuggested)
+		// closing the dialog refuses the connection without remembering it
+		dialog.SetCloseResponse(dismissedResponse)
+		dialog.SetDefaultResponse(string(approval.AllowOnce))
+		dialog.ConnectResponse(func(response string) {
+			if response == dismissedResponse {
+				answer <- ""
+				return
+			}
+			answer <- approval.Decision(response)
+		})
+		prompter.present(dialog)
+	})
+
+	select {
+	case decision := <-answer:
+		return decision
+	case <-canceled:
+		// runs after the dialog was created, idle callbacks are called in order
+		glib.IdleAdd(func() { dialog.ForceClose() })
+		return ""
+	}
+}
+
+func (prompter dialogPrompter) Undelivered(request approval.Request, decision approval.Decision, err error) {
+	glib.IdleAdd(func() {
+		dialog := adw.NewAlertDialog("Answer not delivered", fmt.Sprintf("Your answer for the extension %s in %s could not be delivered: %s. The connection was refused, you will be asked again when it connects the next time.", request.Extension, request.Browser, err))
+		dialog.AddResponse("close", "Close")
+		prompter.present(dialog)
+	})
+}
+
+func (prompter dialogPrompter) present(dialog *adw.AlertDialog) {
+	window := prompter.app.ActiveWindow()
+	if window == nil {
+		dialog.Present(nil)
+		return
+	}
+	dialog.Present(window)
+}
+
+const dismissedResponse = "dismissed"
@@ -1,12 +1,17 @@
 package userapp_settings
 
 import (
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/taukakao/browser-glue/gui/resources"
 	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
+	"github.com/taukakao/browser-glue/lib/util"
 )
 
 func NewUserappSettings(configFile config.NativeConfigFile) gtk.Widgetter {
@@ -16,26 +21,72 @@ func NewUserappSettings(configFile config.NativeConfigFile) gtk.Widgetter {
 
 	page := builder.GetObject("userapp_settings").Cast().(*adw.StatusPage)
 	enableSwitch := builder.GetObject("enable_switch").Cast().(*adw.SwitchRow)
+	enableDurationRow := builder.GetObject("enable_duration_row").Cast().(*adw.ComboRow)
+	manifestConflictRow := builder.GetObject("manifest_conflict_row").Cast().(*adw.ActionRow)
+	replaceManifestButton := builder.GetObject("replace_manifest_button").Cast().(*gtk.Button)
 	execInfo := builder.GetObject("exec_info").Cast().(*adw.ActionRow)
 	configPathInfo := builder.GetObject("config_path_info").Cast().(*adw.ActionRow)
 	extensionsInfo := builder.GetObject("extensions_info").Cast().(*adw.ActionRow)
 	browserInfo := builder.GetObject("browser_info").Cast().(*adw.ActionRow)
+	envEntry := builder.GetObject("env_entry").Cast().(*adw.EntryRow)
+	clearEnvSwitch := builder.GetObject("clear_env_switch").Cast().(*adw.SwitchRow)
+	extraArgsEntry := builder.GetObject("extra_args_entry").Cast().(*adw.EntryRow)
+	workingDirEntry := builder.GetObject("working_dir_entry").Cast().(*adw.EntryRow)
+	strictPeerSwitch := builder.GetObject("strict_peer_switch").Cast().(*adw.SwitchRow)
+	executablePinRow := builder.GetObject("executable_pin_row").Cast().(*adw.ActionRow)
+	approveButton := builder.GetObject("approve_button").Cast().(*gtk.Button)
+	decisionsGroup := builder.GetObject("decisions_group").Cast().(*adw.PreferencesGroup)
+	extensionsGroup := builder.GetObject("extensions_group").Cast().(*adw.PreferencesGroup)
+	allowedExtensionsEntry := builder.GetObject("allowed_extensions_entry").Cast().(*adw.EntryRow)
+	blockedExtensionsEntry := builder.GetObject("blocked_extensions_entry").Cast().(*adw.EntryRow)
 
 	page.SetTitle(configFile.Content.Name)
 	page.SetDescription(configFile.Content.Description)
 
 	enableSwitch.SetActive(configFile.IsEnabled())
+	showEnabledUntil(&configFile, enableDurationRow)
 	enableSwitch.Connect("notify::active", func(enableSwitch *adw.SwitchRow) {
 		enable := enableSwitch.Active()
 
 		if enable {
-			configFile.Enable()
+			enableForSelectedDuration(&configFile, enableDurationRow)
 		} else {
 			configFile.Disable()
 		}
+		showEnabledUntil(&configFile, enableDurationRow)
+		manifestConflictRow.SetVisible(configFile.HasForeignManifest())
+	})
+	enableDurationRow.Connect("notify::selected", func(enableDurationRow *adw.ComboRow) {
+		if !enableSwitch.Active() {
+			return
+		}
+		enableForSelectedDuration(&configFile, enableDurationRow)
+		showEnabledUntil(&configFile, enableDurationRow)
+	})
+	manifestConflictRow.SetVisible(configFile.HasForeignManifest())
+	replaceManifestButton.ConnectClicked(func() {
+		err := configFile.BackupForeignManifest()
+		if err == nil && enableSwitch.Active() {
+			enableForSelectedDuration(&configFile, enableDurationRow)
+		}
+		manifestConflictRow.SetVisible(configFile.HasForeignManifest())
 	})
 
-	execInfo.SetSubtitle(configFile.Content.Executable)
+	if configFile.PolicyState() != "" {
+		lockWidget(enableSwitch)
+		lockWidget(enableDurationRow)
+	}
+	policyErr := settings.SystemPolicyError()
+	if policyErr != nil {
+		enableSwitch.SetSubtitle(policyErr.Error())
+		enableSwitch.AddCSSClass("error")
+	}
+
+	if configFile.FlatpakHostId != "" {
+		execInfo.SetSubtitle(configFile.Content.Executable + "\ninside the Flatpak app " + configFile.FlatpakHostId)
+	} else {
+		execInfo.SetSubtitle(configFile.Content.Executable)
+	}
 
 	configPathInfo.SetSubtitle(configFile.Path)
 
@@ -43,5 +94,236 @@ func NewUserappSettings(configFile config.NativeConfigFile) gtk.Widgetter {
 
 	browserInfo.SetSubtitle(browser.GetName())
 
+	appSettings := settings.GetAppSettings(browser, configFile.Name())
+	lockedKeys := settings.LockedAppSettings(browser, configFile.Name())
+	lockIfLocked := func(widget gtk.Widgetter, keys ...string) {
+		for _, key := range keys {
+			if slices.Contains(lockedKeys, key) {
+				lockWidget(widget)
+				return
+			}
+		}
+	}
+
+	extensionSwitches := []*adw.SwitchRow{}
+	updatingSwitches := false
+	// a rule like a glob can still block an extension after its switch was turned on
+	updateExtensionSwitches := func() {
+		updatingSwitches = true
+		defer func() { updatingSwitches = false }()
+		appSettings := settings.GetAppSettings(browser, configFile.Name())
+		for index, extension := range configFile.Content.GetExtensions() {
+			extensionSwitches[index].SetActive(appSettings.ExtensionAllowed(extension))
+		}
+	}
+	for _, extension := range configFile.Content.GetExtensions() {
+		extensionSwitch := adw.NewSwitchRow()
+		extensionSwitch.SetTitle(extension)
+		extensionSwitch.SetActive(appSettings.ExtensionAllowed(extension))
+		extensionSwitch.Connect("notify::active", func(extensionSwitch *adw.SwitchRow) {
+			if updatingSwitches {
+				return
+			}
+			err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+				setExtensionAllowed(appSettings, extension, extensionSwitch.Active())
+			})
+			if err == nil {
+				// rewrites the manifest in the flatpak folder
+				configFile.IsEnabled()
+			}
+			updateExtensionSwitches()
+		})
+		lockIfLocked(extensionSwitch, "allowedExtensions", "blockedExtensions")
+		extensionsGroup.Add(extensionSwitch)
+		extensionSwitches = append(extensionSwitches, extensionSwitch)
+	}
+
+	allowedExtensionsEntry.SetText(strings.Join(appSettings.AllowedExtensions, " "))
+	allowedExtensionsEntry.ConnectApply(func() {
+		err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.AllowedExtensions = strings.Fields(allowedExtensionsEntry.Text())
+		})
+		showEntryError(allowedExtensionsEntry, err)
+		if err == nil {
+			configFile.IsEnabled()
+			updateExtensionSwitches()
+		}
+	})
+
+	blockedExtensionsEntry.SetText(strings.Join(appSettings.BlockedExtensions, " "))
+	blockedExtensionsEntry.ConnectApply(func() {
+		err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.BlockedExtensions = strings.Fields(blockedExtensionsEntry.Text())
+		})
+		showEntryError(blockedExtensionsEntry, err)
+		if err == nil {
+			configFile.IsEnabled()
+			updateExtensionSwitches()
+		}
+	})
+
+	envEntry.SetText(strings.Join(appSettings.Env, " "))
+	envEntry.ConnectApply(func() {
+		err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.Env = strings.Fields(envEntry.Text())
+		})
+		showEntryError(envEntry, err)
+	})
+
+	clearEnvSwitch.SetActive(appSettings.ClearEnv)
+	clearEnvSwitch.Connect("notify::active", func(clearEnvSwitch *adw.SwitchRow) {
+		updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.ClearEnv = clearEnvSwitch.Active()
+		})
+	})
+
+	extraArgsEntry.SetText(strings.Join(appSettings.ExtraArgs, " "))
+	extraArgsEntry.ConnectApply(func() {
+		err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.ExtraArgs = strings.Fields(extraArgsEntry.Text())
+		})
+		showEntryError(extraArgsEntry, err)
+	})
+
+	workingDirEntry.SetText(appSettings.WorkingDir)
+	workingDirEntry.ConnectApply(func() {
+		err := updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.WorkingDir = strings.TrimSpace(workingDirEntry.Text())
+		})
+		showEntryError(workingDirEntry, err)
+	})
+
+	strictPeerSwitch.SetActive(appSettings.PeerVerification == settings.PeerVerificationStrict)
+	strictPeerSwitch.Connect("notify::active", func(strictPeerSwitch *adw.SwitchRow) {
+		updateAppSettings(browser, configFile.Name(), func(appSettings *settings.AppSettings) {
+			appSettings.PeerVerification = settings.PeerVerificationPermissive
+			if strictPeerSwitch.Active() {
+				appSettings.PeerVerification = settings.PeerVerificationStrict
+			}
+		})
+	})
+
+	lockIfLocked(allowedExtensionsEntry, "allowedExtensions")
+	lockIfLocked(blockedExtensionsEntry, "blockedExtensions")
+	lockIfLocked(envEntry, "env")
+	lockIfLocked(clearEnvSwitch, "clearEnv")
+	lockIfLocked(extraArgsEntry, "extraArgs")
+	lockIfLocked(workingDirEntry, "workingDir")
+	lockIfLocked(strictPeerSwitch, "peerVerification")
+	lockIfLocked(decisionsGroup, "extensionDecisions")
+
+	decisionsGroup.SetVisible(len(appSettings.ExtensionDecisions) > 0)
+	for _, decision := range appSettings.ExtensionDecisions {
+		decisionsGroup.Add(newDecisionRow(browser, configFile.Name(), decision, decisionsGroup))
+	}
+
+	showExecutableStatus(&configFile, executablePinRow, approveButton)
+	approveButton.ConnectClicked(func() {
+		configFile.PinExecutable()
+		showExecutableStatus(&configFile, executablePinRow, approveButton)
+	})
+
 	return page
 }
+
+// setExtensionAllowed changes the rules for a single extension, so rules matching other extensions are kept.
+func setExtensionAllowed(appSettings *settings.AppSettings, extension string, allowed bool) {
+	extension = util.NormalizeExtensionName(extension)
+	appSettings.BlockedExtensions = slices.DeleteFunc(appSettings.BlockedExtensions, func(rule string) bool { return util.NormalizeExtensionName(rule) == extension })
+	if !allowed {
+		appSettings.BlockedExtensions = append(appSettings.BlockedExtensions, extension)
+		return
+	}
+	if !appSettings.ExtensionAllowed(extension) {
+		appSettings.AllowedExtensions = append(appSettings.AllowedExtensions, extension)
+	}
+}
+
+// enableDurations match the entries of the enable_duration_row, 0 keeps the app enabled.
+var enableDurations = []time.Duration{0, 15 * time.Minute, time.Hour, 2 * time.Hour, 8 * time.Hour, 24 * time.Hour}
+
+func enableForSelectedDuration(configFile *config.NativeConfigFile, enableDurationRow *adw.ComboRow) {
+	duration := enableDurations[enableDurationRow.Selected()]
+	if duration == 0 {
+		configFile.Enable()
+		return
+	}
+	configFile.EnableFor(duration)
+}
+
+func showEnabledUntil(configFile *config.NativeConfigFile, enableDurationRow *adw.ComboRow) {
+	enabledUntil := configFile.EnabledUntil()
+	if enabledUntil.IsZero() {
+		enableDurationRow.SetSubtitle("")
+		return
+	}
+	enableDurationRow.SetSubtitle("Disabled automatically at " + enabledUntil.Local().Format(time.DateTime))
+}
+
+func newDecisionRow(browser util.Browser, name string, decision settings.ExtensionDecision, decisionsGroup *adw.PreferencesGroup) *adw.ActionRow {
+	row := adw.NewActionRow()
+	row.SetTitle(decision.Extension)
+	if decision.Decision == settings.DecisionAllow {
+		row.SetSubtitle("Always allowed")
+	} else {
+		row.SetSubtitle("Denied")
+	}
+
+	revokeButton := gtk.NewButtonWithLabel("Revoke")
+	revokeButton.SetVAlign(gtk.AlignCenter)
+	revokeButton.ConnectClicked(func() {
+		err := updateAppSettings(browser, name, func(appSettings *settings.AppSettings) {
+			appSettings.SetExtensionDecision(decision.Extension, "")
+		})
+		if err == nil {
+			decisionsGroup.Remove(row)
+		}
+	})
+	row.AddSuffix(revokeButton)
+
+	return row
+}
+
+// showExecutableStatus only offers approving executables that changed or were never approved.
+func showExecutableStatus(configFile *config.NativeConfigFile, row *adw.ActionRow, approveButton *gtk.Button) {
+	status, err := configFile.VerifyExecutable()
+	switch status {
+	case config.ExecutableApproved:
+		row.SetSubtitle("Unchanged since it was approved")
+	case config.ExecutableChanged:
+		row.SetSubtitle("Changed since it was approved, the host is not started until it is approved again\n" + err.Error())
+	case config.ExecutableNotPinned:
+		row.SetSubtitle("Never approved, the host is not started until it is approved")
+	case config.ExecutableUnverifiable:
+		row.SetSubtitle("Not on this system, it can't be pinned")
+	}
+	approveButton.SetVisible(status == config.ExecutableChanged || status == config.ExecutableNotPinned)
+}
+
+// updateAppSettings reads the settings again, so changes made somewhere else are not lost.
+func updateAppSettings(browser util.Browser, name string, update func(appSettings *settings.AppSettings)) error {
+	appSettings := settings.GetAppSettings(browser, name)
+	update(&appSettings)
+
+	err := settings.SetAppSettings(browser, appSettings)
+	if err != nil {
+		logs.Warn("could not save settings of", name, ":", err)
+	}
+	return err
+}
+
+// lockWidget shows that a setting is set by the system policy and can't be changed.
+func lockWidget(widget gtk.Widgetter) {
+	gtk.BaseWidget(widget).SetSensitive(false)
+	gtk.BaseWidget(widget).SetTooltipText("Locked by the system policy")
+}
+
+func showEntryError(entry *adw.EntryRow, err error) {
+	if err != nil {
+		entry.AddCSSClass("error")
+		entry.SetTooltipText(err.Error())
+		return
+	}
+	entry.RemoveCSSClass("error")
+	entry.SetTooltipText("")
+}
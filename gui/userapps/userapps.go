@@ -7,6 +7,7 @@ import (
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/taukakao/browser-glue/gui/resources"
 	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
@@ -20,6 +21,10 @@ func NewUserappsList(browser util.Browser, clickItemCallback func(config.NativeC
 	userappsPage.SetIconName(browser.GetFlatpakId())
 
 	userappsPage.SetTitle(fmt.Sprintf("Native Applications for %s", browser.GetName()))
+	policyErr := settings.SystemPolicyError()
+	if policyErr != nil {
+		userappsPage.SetDescription(policyErr.Error())
+	}
 
 	configFiles, err := config.CollectConfigFiles(browser)
 	if err != nil {
@@ -0,0 +1,98 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+)
+
+// Decision is the answer of the user to an approval prompt.
+type Decision string
+
+const (
+	// AllowOnce only allows the connection that caused the prompt.
+	AllowOnce Decision = "once"
+	// AllowAlways allows the extension until the decision is revoked.
+	AllowAlways Decision = "always"
+	// Deny refuses the extension until the decision is revoked.
+	Deny Decision = "deny"
+)
+
+const (
+	PolicyApprover       = "policy"
+	GUIApprover          = "gui"
+	NotificationApprover = "notification"
+	TerminalApprover     = "terminal"
+)
+
+// ErrUnavailable is returned by approvers that can't ask the user right now, the next approver is asked instead.
+var ErrUnavailable = errors.New("approver is not available")
+
+var ErrNoApprover = errors.New("no approver could ask the user")
+
+// Request describes the connection the user is asked about.
+type Request struct {
+	Browser    string `json:"browser"`
+	HostName   string `json:"host_name"`
+	AppName    string `json:"app_name"`
+	Extension  string `json:"extension"`
+	Executable string `json:"executable"`
+}
+
+// Question is shown to the user by approvers.
+func (request Request) Question() string {
+	return fmt.Sprintf("Allow the extension %s in %s to start the native app %s (%s)?", request.Extension, request.Browser, request.HostName, request.Executable)
+}
+
+// Approver asks the user to approve a connection.
+type Approver interface {
+	Ask(ctx context.Context, request Request) (Decision, error)
+}
+
+// NewApprover returns the approver with the name used in the settings.
+func NewApprover(name string) (Approver, error) {
+	switch name {
+	case PolicyApprover:
+		return policyApprover{}, nil
+	case GUIApprover:
+		return guiApprover{}, nil
+	case NotificationApprover:
+		return notificationApprover{}, nil
+	case TerminalApprover:
+		return terminalApprover{}, nil
+	default:
+		return nil, fmt.Errorf("unknown approver %s", name)
+	}
+}
+
+// Ask tries the approvers in order until one of them answers and returns its decision and name.
+func Ask(ctx context.Context, request Request, approverNames []string) (Decision, string, error) {
+	for _, name := range approverNames {
+		approver, err := NewApprover(name)
+		if err != nil {
+			logs.Warn("ignoring approver", err)
+			continue
+		}
+
+		decision, err := approver.Ask(ctx, request)
+		if errors.Is(err, ErrUnavailable) {
+			logs.Debug("approver", name, "not available:", err)
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("approver %s failed: %w", name, err)
+			return "", name, err
+		}
+		if !decision.valid() {
+			return "", name, fmt.Errorf("approver %s answered with the unknown decision %s", name, decision)
+		}
+		return decision, name, nil
+	}
+	return "", "", ErrNoApprover
+}
+
+func (decision Decision) valid() bool {
+	return decision == AllowOnce || decision == AllowAlways || decision == Deny
+}
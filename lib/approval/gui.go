@@ -0,0 +1,138 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/protocol"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// GetPromptSocketPath returns the socket the GUI listens on for approval prompts.
+func GetPromptSocketPath() string {
+	return filepath.Join(util.GetCustomRuntimeDir(), "approval-prompts")
+}
+
+type answer struct {
+	Decision Decision `json:"decision"`
+}
+
+// guiApprover asks the GUI to show a dialog, it is only available while the GUI is running.
+type guiApprover struct{}
+
+func (guiApprover) Ask(ctx context.Context, request Request) (Decision, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", GetPromptSocketPath())
+	if err != nil {
+		return "", fmt.Errorf("%w: GUI is not running: %w", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	stopAfterFunc := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopAfterFunc()
+
+	err = protocol.WriteFrame(conn, request)
+	if err != nil {
+		return "", fmt.Errorf("could not send prompt to GUI: %w", err)
+	}
+
+	answer := answer{}
+	err = protocol.ReadFrame(conn, &answer)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("no answer from the GUI: %w", ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read answer of GUI: %w", err)
+	}
+	if answer.Decision == "" {
+		return "", errors.New("prompt was dismissed")
+	}
+	return answer.Decision, nil
+}
+
+// Prompter shows the approval prompts of servers to the user.
+type Prompter interface {
+	// Prompt is called in its own goroutine for every prompt, an empty decision means the user dismissed it.
+	// canceled is closed when the server stops waiting for the answer, the prompt should be closed then.
+	Prompt(request Request, canceled <-chan struct{}) Decision
+	// Undelivered tells the user that their decision did not reach the server.
+	Undelivered(request Request, decision Decision, err error)
+}
+
+// ErrPromptCanceled is reported to Undelivered if the server stopped waiting before the user decided.
+var ErrPromptCanceled = errors.New("the app stopped waiting for the answer")
+
+// ServePrompts answers approval prompts of servers with the decisions of prompter until the listener is closed.
+func ServePrompts(prompter Prompter) (net.Listener, error) {
+	socketPath := GetPromptSocketPath()
+	err := util.MkdirSecure(filepath.Dir(socketPath), 0o700)
+	if err != nil {
+		err = fmt.Errorf("can't create folder for approval prompts: %w", err)
+		logs.Error(err)
+		return nil, err
+	}
+	// a socket left behind by a crashed GUI would make listening fail
+	err = util.RemoveSocket(socketPath)
+	if err != nil {
+		err = fmt.Errorf("can't remove old approval prompt socket: %w", err)
+		logs.Error(err)
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		err = fmt.Errorf("can't listen for approval prompts on %s: %w", socketPath, err)
+		logs.Error(err)
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				logs.Debug("stopped listening for approval prompts", err)
+				return
+			}
+			go answerPrompt(conn, prompter)
+		}
+	}()
+	return listener, nil
+}
+
+func answerPrompt(conn net.Conn, prompter Prompter) {
+	defer conn.Close()
+
+	request := Request{}
+	err := protocol.ReadFrame(conn, &request)
+	if err != nil {
+		logs.Warn("could not read approval prompt", err)
+		return
+	}
+
+	// the server sends nothing after the request, so a read only returns once it closed the connection
+	canceled := make(chan struct{})
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(canceled)
+	}()
+
+	decision := prompter.Prompt(request, canceled)
+	select {
+	case <-canceled:
+		err = ErrPromptCanceled
+	default:
+		err = protocol.WriteFrame(conn, answer{Decision: decision})
+	}
+	if err == nil {
+		return
+	}
+	if decision == "" {
+		logs.Debug("approval prompt ended without an answer", err)
+		return
+	}
+	logs.Warn("could not answer approval prompt", err)
+	prompter.Undelivered(request, decision, err)
+}
@@ -0,0 +1,49 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// notificationApprover shows a desktop notification with an action for each decision.
+// It needs a notify-send that supports --action and --wait.
+type notificationApprover struct{}
+
+func (notificationApprover) Ask(ctx context.Context, request Request) (Decision, error) {
+	notifySend, err := exec.LookPath("notify-send")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	cmd := exec.CommandContext(ctx, notifySend,
+		"--app-name=Browser Glue",
+		"--urgency=critical",
+		"--wait",
+		"--action="+string(AllowOnce)+"=Allow once",
+		"--action="+string(AllowAlways)+"=Always allow",
+		"--action="+string(Deny)+"=Deny",
+		"Approve native app",
+		request.Question(),
+	)
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("notification was not answered: %w", ctx.Err())
+	}
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		// older versions don't know the options
+		return "", fmt.Errorf("%w: notify-send failed: %s", ErrUnavailable, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	action := strings.TrimSpace(string(output))
+	if action == "" {
+		return "", errors.New("notification was dismissed")
+	}
+	return Decision(action), nil
+}
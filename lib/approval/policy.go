@@ -0,0 +1,93 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"github.com/taukakao/browser-glue/lib/settings"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// PolicyRule answers approval prompts without asking the user.
+// Empty fields match everything, the others can use patterns like "*@example.org".
+type PolicyRule struct {
+	Browser   string `mapstructure:"browser"`
+	Host      string `mapstructure:"host"`
+	Extension string `mapstructure:"extension"`
+	// Decision is either allow or deny, it is applied every time and never remembered.
+	Decision string `mapstructure:"decision"`
+}
+
+// GetPolicyFilePath returns the file containing the rules of the policy approver.
+func GetPolicyFilePath() string {
+	return filepath.Join(util.GetCustomUserConfigDir(), "approval-policy.toml")
+}
+
+// policyApprover answers with the first matching [[rules]] entry in the policy file.
+type policyApprover struct{}
+
+func (policyApprover) Ask(ctx context.Context, request Request) (Decision, error) {
+	rules, err := readPolicyRules(GetPolicyFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: no policy file", ErrUnavailable)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(request) {
+			continue
+		}
+		switch rule.Decision {
+		case settings.DecisionAllow:
+			return AllowOnce, nil
+		case settings.DecisionDeny:
+			return Deny, nil
+		default:
+			return "", fmt.Errorf("policy rule for %s has to decide %s or %s: %s", rule.Extension, settings.DecisionAllow, settings.DecisionDeny, rule.Decision)
+		}
+	}
+	return "", fmt.Errorf("%w: no policy rule matches", ErrUnavailable)
+}
+
+func readPolicyRules(policyPath string) ([]PolicyRule, error) {
+	_, err := os.Stat(policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := viper.New()
+	policy.SetConfigFile(policyPath)
+	policy.SetConfigType("toml")
+	err = policy.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not read policy file %s: %w", policyPath, err)
+	}
+
+	rules := []PolicyRule{}
+	err = policy.UnmarshalKey("rules", &rules)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rules of policy file %s: %w", policyPath, err)
+	}
+	return rules, nil
+}
+
+func (rule PolicyRule) matches(request Request) bool {
+	return matchesPattern(rule.Browser, request.Browser) &&
+		matchesPattern(rule.Host, request.HostName) &&
+		matchesPattern(util.NormalizeExtensionName(rule.Extension), util.NormalizeExtensionName(request.Extension))
+}
+
+func matchesPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
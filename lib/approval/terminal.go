@@ -0,0 +1,58 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// terminalApprover asks on the terminal the server was started from.
+type terminalApprover struct{}
+
+func (terminalApprover) Ask(ctx context.Context, request Request) (Decision, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("%w: server is not running in a terminal", ErrUnavailable)
+	}
+
+	startReadingTerminal.Do(func() { go readTerminalLines() })
+
+	for {
+		pterm.Info.Println(request.Question())
+		pterm.Info.Println("Answer with (o)nce, (a)lways or (d)eny:")
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("prompt was not answered: %w", ctx.Err())
+		case line, ok := <-terminalLines:
+			if !ok {
+				return "", fmt.Errorf("%w: terminal was closed", ErrUnavailable)
+			}
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "o", "once":
+				return AllowOnce, nil
+			case "a", "always":
+				return AllowAlways, nil
+			case "d", "deny":
+				return Deny, nil
+			}
+		}
+	}
+}
+
+// terminalLines is shared by all prompts, so a prompt that timed out doesn't swallow the answer to the next one.
+var terminalLines = make(chan string)
+var startReadingTerminal sync.Once
+
+func readTerminalLines() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		terminalLines <- scanner.Text()
+	}
+	close(terminalLines)
+}
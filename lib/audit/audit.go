@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// Events of a host, a launch is recorded before the host is started and again once it ended.
+const (
+	EventLaunched = "launched"
+	EventEnded    = "ended"
+)
+
+// Record describes one event of a host launch.
+type Record struct {
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+	// Event is EventLaunched or EventEnded, records written before there were events are EventEnded.
+	Event     string `json:"event,omitempty"`
+	Browser   string `json:"browser"`
+	Extension string `json:"extension"`
+	// HostName is the name from the manifest, App the name of the manifest file.
+	HostName   string `json:"hostName"`
+	App        string `json:"app"`
+	Executable string `json:"executable"`
+	// SHA256 is "unverifiable" if the executable is not on this system.
+	SHA256 string `json:"sha256,omitempty"`
+	// PeerPID is the process of the client, 0 if it is unknown.
+	PeerPID int `json:"peerPid,omitempty"`
+	// DurationMs, ExitStatus and Reason are only set for EventEnded.
+	DurationMs int64 `json:"durationMs,omitempty"`
+	// ExitStatus describes how the host exited, including violated limits.
+	ExitStatus string `json:"exitStatus,omitempty"`
+	// Reason is why the connection ended or why the host could not be started.
+	Reason string `json:"reason,omitempty"`
+}
+
+// EventName returns the event of the record, also for records written before there were events.
+func (record Record) EventName() string {
+	if record.Event == "" {
+		return EventEnded
+	}
+	return record.Event
+}
+
+// Duration returns how long the host ran.
+func (record Record) Duration() time.Duration {
+	return time.Duration(record.DurationMs) * time.Millisecond
+}
+
+// Filter selects records, empty fields match everything.
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	Browser   string
+	App       string
+	Extension string
+}
+
+func (filter Filter) matches(record Record) bool {
+	switch {
+	case !filter.Since.IsZero() && record.Time.Before(filter.Since):
+		return false
+	case !filter.Until.IsZero() && record.Time.After(filter.Until):
+		return false
+	case filter.Browser != "" && filter.Browser != record.Browser:
+		return false
+	case filter.App != "" && filter.App != record.App && filter.App != record.HostName:
+		return false
+	case filter.Extension != "" && util.NormalizeExtensionName(filter.Extension) != util.NormalizeExtensionName(record.Extension):
+		return false
+	}
+	return true
+}
+
+// MaxLogSize is the size after which the audit log is rotated.
+const MaxLogSize = 4 * 1024 * 1024
+
+// RotatedLogs is the number of rotated files that are kept, older records are deleted.
+const RotatedLogs = 5
+
+// GetLogPath returns the file new records are appended to.
+func GetLogPath() string {
+	return filepath.Join(util.GetCustomUserStateDir(), "audit.jsonl")
+}
+
+func rotatedLogPath(number int) string {
+	return GetLogPath() + "." + strconv.Itoa(number)
+}
+
+// appendMutex orders records of this process, the lock file orders them between processes.
+var appendMutex sync.Mutex
+
+// Append adds a record to the audit log.
+func Append(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	appendMutex.Lock()
+	defer appendMutex.Unlock()
+
+	err = util.MkdirSecure(filepath.Dir(GetLogPath()), 0o700)
+	if err != nil {
+		err = fmt.Errorf("can't create folder for the audit log: %w", err)
+		logs.Error(err)
+		return err
+	}
+
+	unlock, err := lockLog()
+	if err != nil {
+		err = fmt.Errorf("can't lock the audit log: %w", err)
+		logs.Error(err)
+		return err
+	}
+	defer unlock()
+
+	info, err := os.Lstat(GetLogPath())
+	if err == nil && info.Size() >= MaxLogSize {
+		rotate()
+	}
+
+	file, err := util.OpenAppend(GetLogPath(), 0o600)
+	if err != nil {
+		err = fmt.Errorf("can't open the audit log: %w", err)
+		logs.Error(err)
+		return err
+	}
+	defer file.Close()
+
+	// a single write keeps the line in one piece
+	_, err = file.Write(line)
+	if err != nil {
+		err = fmt.Errorf("can't write to the audit log: %w", err)
+		logs.Error(err)
+		return err
+	}
+	return nil
+}
+
+// rotate shifts the rotated files by one, the oldest one is dropped.
+func rotate() {
+	for number := RotatedLogs - 1; number >= 1; number-- {
+		err := os.Rename(rotatedLogPath(number), rotatedLogPath(number+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logs.Warn("could not rotate audit log", rotatedLogPath(number), err)
+		}
+	}
+	err := os.Rename(GetLogPath(), rotatedLogPath(1))
+	if err != nil {
+		logs.Warn("could not rotate audit log", GetLogPath(), err)
+	}
+}
+
+func lockLog() (func(), error) {
+	lockPath := filepath.Join(filepath.Dir(GetLogPath()), ".audit.lock")
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// Query returns the records matching the filter, oldest first.
+func Query(filter Filter) ([]Record, error) {
+	paths := []string{}
+	for number := RotatedLogs; number >= 1; number-- {
+		paths = append(paths, rotatedLogPath(number))
+	}
+	paths = append(paths, GetLogPath())
+
+	records := []Record{}
+	for _, path := range paths {
+		fileRecords, err := readRecords(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return records, fmt.Errorf("can't read audit log %s: %w", path, err)
+		}
+		for _, record := range fileRecords {
+			if filter.matches(record) {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+func readRecords(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		record := Record{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			logs.Warn("skipping invalid line", lineNumber, "of audit log", path, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 
 	"github.com/taukakao/browser-glue/lib/logs"
 	"github.com/taukakao/browser-glue/lib/util"
@@ -61,32 +62,31 @@ func (config *NativeMessagingConfig) ParseFile(path string) error {
 	return nil
 }
 
+// marshal returns the content browser-glue writes for the config.
+func (config *NativeMessagingConfig) marshal() ([]byte, error) {
+	return json.MarshalIndent(*config, "", "    ")
+}
+
 func (config *NativeMessagingConfig) WriteFile(path string) error {
 	var err error
 
-	pathNew := filepath.Join(filepath.Dir(path), filepath.Base(path)+".new")
-
-	data, err := json.MarshalIndent(*config, "", "    ")
+	data, err := config.marshal()
 	if err != nil {
 		err = fmt.Errorf("creating the new config file failed: %w", err)
 		logs.Error(err)
 		return err
 	}
 
-	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	err = util.MkdirSecure(filepath.Dir(path), 0o755)
 	if err != nil {
-		logs.Warn("could not create native messaging config folder", err)
-	}
-
-	err = os.WriteFile(pathNew, data, 0o644)
-	if err != nil {
-		err = fmt.Errorf("writing the config file to %s failed: %w", pathNew, err)
+		err = fmt.Errorf("could not create native messaging config folder: %w", err)
 		logs.Error(err)
 		return err
 	}
-	err = os.Rename(pathNew, path)
+
+	err = util.ReplaceFile(path, data, 0o644)
 	if err != nil {
-		err = fmt.Errorf("moving the temporary file %s into position at %s failed: %w", pathNew, path, err)
+		err = fmt.Errorf("writing the config file to %s failed: %w", path, err)
 		logs.Error(err)
 		return err
 	}
@@ -98,15 +98,11 @@ func (config *NativeMessagingConfig) IsIdentical(configComp *NativeMessagingConf
 }
 
 func (config NativeMessagingConfig) CreateCopy() *NativeMessagingConfig {
-	allowedExtensions := make([]string, len(config.AllowedExtensions))
-	allowedOrigins := make([]string, len(config.AllowedOrigins))
-	copy(allowedExtensions, config.AllowedExtensions)
-	copy(allowedOrigins, config.AllowedOrigins)
-	config.AllowedExtensions = allowedExtensions
-	config.AllowedOrigins = allowedOrigins
+	config.AllowedExtensions = slices.Clone(config.AllowedExtensions)
+	config.AllowedOrigins = slices.Clone(config.AllowedOrigins)
 	return &config
 }
 
 func (config *NativeMessagingConfig) ConvertToCustomConfig(browser util.Browser) {
-	config.Executable = browser.GetClientPath()
+	config.Executable = browser.GetHostClientPath(config.Name)
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"time"
 
 	"github.com/taukakao/browser-glue/lib/logs"
 	"github.com/taukakao/browser-glue/lib/settings"
@@ -15,7 +16,9 @@ import (
 type NativeConfigFile struct {
 	Path    string
 	Content NativeMessagingConfig
-	browser util.Browser
+	// FlatpakHostId is set if the host is a flatpak app, the path in the config is inside its sandbox.
+	FlatpakHostId string
+	browser       util.Browser
 }
 
 func (config *NativeConfigFile) Name() string {
@@ -34,7 +37,15 @@ func (config *NativeConfigFile) IsEnabled() bool {
 	enabledConfigs := settings.EnabledNativeConfigFiles(config.browser)
 	enabled := slices.Contains(enabledConfigs, config.Name())
 
-	if enabled && !config.flatpakFileExists() {
+	if !enabled && config.PolicyState() == settings.HostForbidden && config.flatpakFileExists() && !config.HasForeignManifest() {
+		logs.Info("removing flatpak config file of forbidden app", config.Name())
+		err := config.deleteConfigInFlatpakDir()
+		if err != nil {
+			logs.Error(fmt.Errorf("could not remove config %s of forbidden app: %w", config.Path, err))
+		}
+	}
+	// a foreign manifest is reported as a conflict instead of being replaced
+	if enabled && !config.flatpakFileUpToDate() && !config.HasForeignManifest() {
 		logs.Info("writing flatpak config file", config.Name())
 		err := config.writeConfigToFlatpakDir()
 		if err != nil {
@@ -46,13 +57,43 @@ func (config *NativeConfigFile) IsEnabled() bool {
 	return enabled
 }
 
+// Enable keeps the app enabled until it is disabled.
 func (config *NativeConfigFile) Enable() error {
-	err := config.writeConfigToFlatpakDir()
+	return config.EnableUntil(time.Time{})
+}
+
+// EnableFor disables the app automatically once the duration passed.
+func (config *NativeConfigFile) EnableFor(duration time.Duration) error {
+	return config.EnableUntil(time.Now().Add(duration))
+}
+
+// EnableUntil disables the app automatically at enabledUntil, if it is not the zero time.
+func (config *NativeConfigFile) EnableUntil(enabledUntil time.Time) error {
+	err := config.checkPolicy(true, enabledUntil)
+	if err != nil {
+		return err
+	}
+	err = config.setEnabledUntil(enabledUntil)
+	if err != nil {
+		return err
+	}
+	err = config.writeConfigToFlatpakDir()
 	if err != nil {
 		err = fmt.Errorf("could not write config to flatpak directory: %w", err)
 		logs.Error(err)
 		return err
 	}
+	// enabling approves an executable that was never approved, only approving it again replaces the pin
+	status, err := config.VerifyExecutable()
+	switch status {
+	case ExecutableNotPinned:
+		err = config.PinExecutable()
+		if err != nil {
+			logs.Warn("the host of", config.Name(), "is not started until its executable is approved with browser-glue apps approve")
+		}
+	case ExecutableChanged:
+		logs.Warn("the host of", config.Name(), "is not started until its executable is approved again with browser-glue apps approve:", err)
+	}
 	err = settings.SetNativeConfigFileEnabled(config.browser, config.Name(), true)
 	if err != nil {
 		err = fmt.Errorf("could not change setting of config file: %w", err)
@@ -63,10 +104,18 @@ func (config *NativeConfigFile) Enable() error {
 }
 
 func (config *NativeConfigFile) Disable() error {
-	err := config.deleteConfigInFlatpakDir()
+	err := config.checkPolicy(false, time.Time{})
+	if err != nil {
+		return err
+	}
+	err = config.deleteConfigInFlatpakDir()
 	if err != nil {
 		logs.Warn("config not deleted from flatpak dir", err)
 	}
+	err = config.setEnabledUntil(time.Time{})
+	if err != nil {
+		logs.Warn("expiry of", config.Name(), "not removed", err)
+	}
 	err = settings.SetNativeConfigFileEnabled(config.browser, config.Name(), false)
 	if err != nil {
 		err = fmt.Errorf("could not change setting of config file: %w", err)
@@ -77,6 +126,51 @@ func (config *NativeConfigFile) Disable() error {
 	return nil
 }
 
+// PolicyState returns settings.HostForced, settings.HostForbidden or an empty string if the user decides.
+func (config *NativeConfigFile) PolicyState() string {
+	return settings.GetHostPolicyState(config.browser, config.Name())
+}
+
+// checkPolicy returns settings.ErrLocked if the system policy does not allow the change, forced apps can't expire.
+func (config *NativeConfigFile) checkPolicy(enable bool, enabledUntil time.Time) error {
+	err := settings.SystemPolicyError()
+	if err != nil && enable {
+		return err
+	}
+	state := config.PolicyState()
+	if state == settings.HostForbidden && enable {
+		return fmt.Errorf("%s can't be enabled, it is %w", config.Name(), settings.ErrLocked)
+	}
+	if state == settings.HostForced && (!enable || !enabledUntil.IsZero()) {
+		return fmt.Errorf("%s is always enabled, it is %w", config.Name(), settings.ErrLocked)
+	}
+	return nil
+}
+
+// EnabledUntil returns the zero time if the app does not expire, apps forced by the system policy never do.
+func (config *NativeConfigFile) EnabledUntil() time.Time {
+	if config.PolicyState() == settings.HostForced {
+		return time.Time{}
+	}
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
+	return appSettings.GetEnabledUntil()
+}
+
+func (config *NativeConfigFile) setEnabledUntil(enabledUntil time.Time) error {
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
+	if appSettings.GetEnabledUntil().Equal(enabledUntil) {
+		return nil
+	}
+	appSettings.SetEnabledUntil(enabledUntil)
+	err := settings.SetAppSettings(config.browser, appSettings)
+	if err != nil {
+		err = fmt.Errorf("could not save when %s is disabled: %w", config.Name(), err)
+		logs.Error(err)
+		return err
+	}
+	return nil
+}
+
 func (config *NativeConfigFile) flatpakConfigPath() string {
 	filename := filepath.Base(config.Path)
 
@@ -100,23 +194,77 @@ func (config *NativeConfigFile) flatpakFileExists() bool {
 	return err == nil
 }
 
-func (config *NativeConfigFile) writeConfigToFlatpakDir() error {
-	flatpakPath := config.flatpakConfigPath()
-	if config.flatpakFileExists() {
-		err := os.Remove(flatpakPath)
-		if err != nil {
-			logs.Warn("could not remove old config file:", err)
-		}
+// flatpakFileUpToDate also catches files written by older versions, which point to a different client path.
+// No file is written while no extension is allowed, so it is up to date if there is none.
+func (config *NativeConfigFile) flatpakFileUpToDate() bool {
+	if len(config.flatpakConfig().GetExtensions()) == 0 {
+		return !config.flatpakFileExists()
+	}
+	existingConfig := NativeMessagingConfig{}
+	err := existingConfig.ParseFile(config.flatpakConfigPath())
+	if err != nil {
+		return false
 	}
 
+	return existingConfig.IsIdentical(config.flatpakConfig())
+}
+
+// flatpakConfig only lists the extensions allowed by the settings, so the browser doesn't let the others start the client.
+func (config *NativeConfigFile) flatpakConfig() *NativeMessagingConfig {
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
 	flatpakConfig := config.Content.CreateCopy()
 	flatpakConfig.ConvertToCustomConfig(config.browser)
-	err := flatpakConfig.WriteFile(flatpakPath)
+	flatpakConfig.AllowedExtensions = slices.DeleteFunc(flatpakConfig.AllowedExtensions, func(extension string) bool { return !appSettings.ExtensionAllowed(extension) })
+	flatpakConfig.AllowedOrigins = slices.DeleteFunc(flatpakConfig.AllowedOrigins, func(extension string) bool { return !appSettings.ExtensionAllowed(extension) })
+	return flatpakConfig
+}
+
+// EnabledExtensions returns the extensions listed in the manifest that are allowed by the settings.
+func (config *NativeConfigFile) EnabledExtensions() []string {
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
+	extensions := []string{}
+	for _, extension := range config.Content.GetExtensions() {
+		if appSettings.ExtensionAllowed(extension) {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
+
+func (config *NativeConfigFile) writeConfigToFlatpakDir() error {
+	flatpakPath := config.flatpakConfigPath()
+	err := config.checkFlatpakFileCreatedByUs()
+	if err != nil {
+		err = fmt.Errorf("not replacing config in flatpak folder: %w", err)
+		logs.Error(err)
+		return err
+	}
+
+	flatpakConfig := config.flatpakConfig()
+	if len(flatpakConfig.GetExtensions()) == 0 {
+		// a manifest without extensions can't be used by the browser and couldn't be read back
+		logs.Warn("no extension is allowed to use", config.Name(), "so there is no config for it in the flatpak folder")
+		if config.flatpakFileExists() {
+			err = os.Remove(flatpakPath)
+			if err != nil {
+				err = fmt.Errorf("could not remove config in flatpak folder: %w", err)
+				logs.Error(err)
+				return err
+			}
+		}
+		config.removeManifestMarker()
+		return nil
+	}
+	err = flatpakConfig.WriteFile(flatpakPath)
 	if err != nil {
 		err = fmt.Errorf("could not create native config in flatpak folder: %w", err)
 		logs.Error(err)
 		return err
 	}
+	err = config.writeManifestMarker()
+	if err != nil {
+		logs.Warn("could not mark", flatpakPath, "as created by browser-glue", err)
+	}
 
 	return nil
 }
@@ -125,15 +273,27 @@ func (config *NativeConfigFile) deleteConfigInFlatpakDir() error {
 	flatpakPath := config.flatpakConfigPath()
 	if !config.flatpakFileExists() {
 		logs.Info("native config file", flatpakPath, "already deleted")
+		config.removeManifestMarker()
+		return config.restoreManifestBackup()
+	}
+	err := config.checkFlatpakFileCreatedByUs()
+	if errors.Is(err, ErrForeignManifest) {
+		logs.Info("not removing", flatpakPath, "it was not created by browser-glue")
 		return nil
 	}
-	err := os.Remove(flatpakPath)
+	if err != nil {
+		err = fmt.Errorf("not removing config in flatpak folder: %w", err)
+		logs.Error(err)
+		return err
+	}
+	err = os.Remove(flatpakPath)
 	if err != nil {
 		err = fmt.Errorf("could not remove config file: %w", err)
 		logs.Error(err)
 		return err
 	}
-	return nil
+	config.removeManifestMarker()
+	return config.restoreManifestBackup()
 }
 
 func CollectEnabledConfigFiles(browser util.Browser) ([]NativeConfigFile, error) {
@@ -180,7 +340,10 @@ func CollectConfigFiles(browser util.Browser) (configFiles []NativeConfigFile, e
 
 	if _, err := os.Lstat(hostFolderPath); errors.Is(err, os.ErrNotExist) {
 		logs.Warn("Host folder created. Logging out and back in might be requred.")
-		os.MkdirAll(hostFolderPath, 0o755)
+		err := util.MkdirSecure(hostFolderPath, 0o755)
+		if err != nil {
+			logs.Warn("could not create host folder", err)
+		}
 	}
 
 	hostConfigFiles, err := collectConfigFilePathsInFolder(hostFolderPath)
@@ -201,6 +364,20 @@ func CollectConfigFiles(browser util.Browser) (configFiles []NativeConfigFile, e
 		configFiles = append(configFiles, NativeConfigFile{Path: hostConfigFile, Content: decoded, browser: browser})
 	}
 
+	flatpakHostConfigFiles, err := collectFlatpakHostConfigFiles(browser)
+	if err != nil {
+		return configFiles, err
+	}
+	for _, flatpakHostConfigFile := range flatpakHostConfigFiles {
+		// settings and the file in the browser folder use the name, so manifests installed on the host win
+		collides := slices.ContainsFunc(configFiles, func(configFile NativeConfigFile) bool { return configFile.Name() == flatpakHostConfigFile.Name() })
+		if collides {
+			logs.Debug("ignoring", flatpakHostConfigFile.Path, "because a config file with the same name exists")
+			continue
+		}
+		configFiles = append(configFiles, flatpakHostConfigFile)
+	}
+
 	return configFiles, nil
 }
 
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// flatpakHostFolders are the folders inside the data folder of a flatpak app where it writes its manifests,
+// flatpak apps get their own XDG_CONFIG_HOME, so both the usual and the redirected folders are checked.
+func flatpakHostFolders(flavour util.BrowserFlavour) []string {
+	switch flavour {
+	case util.FirefoxFlavour:
+		return []string{
+			filepath.Join(".mozilla", "native-messaging-hosts"),
+			filepath.Join("config", "mozilla", "native-messaging-hosts"),
+		}
+	case util.ChromiumFlavour:
+		return []string{
+			filepath.Join(".config", "chromium", "NativeMessagingHosts"),
+			filepath.Join("config", "chromium", "NativeMessagingHosts"),
+		}
+	}
+	return nil
+}
+
+// flatpakExportFolders are exported by flatpak apps and link into the installation of the app.
+func flatpakExportFolders(flavour util.BrowserFlavour) []string {
+	var subFolder string
+	switch flavour {
+	case util.FirefoxFlavour:
+		subFolder = filepath.Join("mozilla", "native-messaging-hosts")
+	case util.ChromiumFlavour:
+		subFolder = filepath.Join("chromium", "native-messaging-hosts")
+	default:
+		return nil
+	}
+	return []string{
+		filepath.Join(util.GetUserDataDir(), "flatpak", "exports", "share", subFolder),
+		filepath.Join("/var/lib/flatpak/exports/share", subFolder),
+	}
+}
+
+// collectFlatpakHostConfigFiles finds manifests of hosts that are flatpak apps themselves.
+// The data folders of browsers are skipped, because that's where our own manifests are written.
+func collectFlatpakHostConfigFiles(browser util.Browser) ([]NativeConfigFile, error) {
+	configFiles := []NativeConfigFile{}
+	flavour := browser.GetFlavour()
+
+	browserIds := []string{}
+	for _, knownBrowser := range util.GetAllBrowsers() {
+		browserIds = append(browserIds, knownBrowser.GetFlatpakId())
+	}
+
+	appDataFolder := filepath.Join(util.GetHomeDirPath(), ".var", "app")
+	appFolders, err := os.ReadDir(appDataFolder)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("can't read flatpak app folders: %w", err)
+		logs.Error(err)
+		return configFiles, err
+	}
+
+	for _, appFolder := range appFolders {
+		appId := appFolder.Name()
+		if !appFolder.IsDir() || slices.Contains(browserIds, appId) {
+			continue
+		}
+		for _, hostFolder := range flatpakHostFolders(flavour) {
+			paths, err := collectConfigFilePathsInFolder(filepath.Join(appDataFolder, appId, hostFolder))
+			if err != nil {
+				continue
+			}
+			configFiles = append(configFiles, parseFlatpakHostConfigFiles(paths, browser, func(string) string { return appId })...)
+		}
+	}
+
+	for _, exportFolder := range flatpakExportFolders(flavour) {
+		paths, err := collectConfigFilePathsInFolder(exportFolder)
+		if err != nil {
+			continue
+		}
+		configFiles = append(configFiles, parseFlatpakHostConfigFiles(paths, browser, flatpakIdOfExport)...)
+	}
+
+	return configFiles, nil
+}
+
+func parseFlatpakHostConfigFiles(paths []string, browser util.Browser, flatpakIdOf func(path string) string) []NativeConfigFile {
+	configFiles := []NativeConfigFile{}
+	for _, path := range paths {
+		hostId := flatpakIdOf(path)
+		if hostId == "" {
+			logs.Warn("can't find the flatpak app of", path)
+			continue
+		}
+
+		decoded := NativeMessagingConfig{}
+		err := decoded.ParseFile(path)
+		if err != nil {
+			logs.Error(fmt.Errorf("failed to parse config file %s: %w", path, err))
+			continue
+		}
+		configFiles = append(configFiles, NativeConfigFile{Path: path, Content: decoded, FlatpakHostId: hostId, browser: browser})
+	}
+	return configFiles
+}
+
+// flatpakIdOfExport follows the exported link to .../app/<id>/<arch>/<branch>/<commit>/export/...
+func flatpakIdOfExport(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(target, string(filepath.Separator))
+	for i := len(parts) - 2; i >= 0; i-- {
+		if parts[i] == "app" && i+1 < len(parts) && slices.Contains(parts[i+1:], "export") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
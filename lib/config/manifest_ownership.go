@@ -0,0 +1,192 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// ErrForeignManifest is returned when a manifest that was not created by browser-glue is in the flatpak folder.
+var ErrForeignManifest = errors.New("a manifest that was not created by browser-glue is in the way")
+
+// HasForeignManifest reports if the manifest in the flatpak folder belongs to someone else.
+// browser-glue does not replace it until it was backed up with BackupForeignManifest.
+func (config *NativeConfigFile) HasForeignManifest() bool {
+	return errors.Is(config.checkFlatpakFileCreatedByUs(), ErrForeignManifest)
+}
+
+// HasManifestBackup reports if a foreign manifest was backed up, it is restored when the app is disabled.
+func (config *NativeConfigFile) HasManifestBackup() bool {
+	_, err := os.Lstat(config.manifestBackupPath())
+	return err == nil
+}
+
+// BackupForeignManifest moves a manifest that was not created by browser-glue out of the way.
+func (config *NativeConfigFile) BackupForeignManifest() error {
+	if !config.HasForeignManifest() {
+		return nil
+	}
+	flatpakPath := config.flatpakConfigPath()
+	backupPath := config.manifestBackupPath()
+	if config.HasManifestBackup() {
+		err := fmt.Errorf("there already is a backup of a manifest of %s in %s", config.Name(), backupPath)
+		logs.Error(err)
+		return err
+	}
+
+	info, err := os.Lstat(flatpakPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(flatpakPath)
+	if err != nil {
+		err = fmt.Errorf("could not read manifest %s: %w", flatpakPath, err)
+		logs.Error(err)
+		return err
+	}
+
+	err = util.MkdirSecure(filepath.Dir(backupPath), 0o700)
+	if err == nil {
+		err = util.ReplaceFile(backupPath, data, info.Mode().Perm())
+	}
+	if err != nil {
+		err = fmt.Errorf("could not back up manifest %s: %w", flatpakPath, err)
+		logs.Error(err)
+		return err
+	}
+
+	err = os.Remove(flatpakPath)
+	if err != nil {
+		err = fmt.Errorf("could not remove manifest %s after backing it up: %w", flatpakPath, err)
+		logs.Error(err)
+		return err
+	}
+	logs.Info("backed up manifest", flatpakPath, "to", backupPath)
+	return nil
+}
+
+// restoreManifestBackup puts a backed up manifest back, but only if nothing else took its place.
+func (config *NativeConfigFile) restoreManifestBackup() error {
+	if !config.HasManifestBackup() {
+		return nil
+	}
+	flatpakPath := config.flatpakConfigPath()
+	backupPath := config.manifestBackupPath()
+
+	info, err := os.Lstat(backupPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		err = fmt.Errorf("could not read backup %s: %w", backupPath, err)
+		logs.Error(err)
+		return err
+	}
+
+	file, err := util.CreateExclusive(flatpakPath, info.Mode().Perm())
+	if err != nil {
+		err = fmt.Errorf("could not restore manifest %s from %s: %w", flatpakPath, backupPath, err)
+		logs.Error(err)
+		return err
+	}
+	_, err = file.Write(data)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(flatpakPath)
+		err = fmt.Errorf("could not restore manifest %s from %s: %w", flatpakPath, backupPath, err)
+		logs.Error(err)
+		return err
+	}
+
+	err = os.Remove(backupPath)
+	if err != nil {
+		logs.Warn("could not remove restored backup", backupPath, err)
+	}
+	logs.Info("restored manifest", flatpakPath)
+	return nil
+}
+
+// checkFlatpakFileCreatedByUs returns util.ErrTampered if the file in the flatpak folder is not a regular file of the user
+// and ErrForeignManifest if it was not written by browser-glue.
+// Files are recognized by their marker, files written by older versions without a marker by having exactly the content they wrote.
+func (config *NativeConfigFile) checkFlatpakFileCreatedByUs() error {
+	flatpakPath := config.flatpakConfigPath()
+	err := util.CheckReplaceable(flatpakPath)
+	if err != nil || !config.flatpakFileExists() {
+		return err
+	}
+
+	data, err := os.ReadFile(flatpakPath)
+	if err != nil {
+		return err
+	}
+	marker, err := os.ReadFile(config.manifestMarkerPath())
+	if err == nil && strings.TrimSpace(string(marker)) == manifestChecksum(data) {
+		return nil
+	}
+
+	if slices.ContainsFunc(config.legacyManifests(), func(legacy []byte) bool { return bytes.Equal(legacy, data) }) {
+		return nil
+	}
+	return fmt.Errorf("%s was not written by browser-glue: %w", flatpakPath, ErrForeignManifest)
+}
+
+// legacyManifests are the manifests older versions wrote for the app.
+// They started the shared client or the client link of the host, either with all extensions or only the allowed ones.
+func (config *NativeConfigFile) legacyManifests() [][]byte {
+	manifests := [][]byte{}
+	for _, flatpakConfig := range []*NativeMessagingConfig{config.Content.CreateCopy(), config.flatpakConfig()} {
+		for _, executable := range []string{config.browser.GetClientPath(), config.browser.GetHostClientPath(config.Content.Name)} {
+			flatpakConfig.Executable = executable
+			data, err := flatpakConfig.marshal()
+			if err == nil {
+				manifests = append(manifests, data)
+			}
+		}
+	}
+	return manifests
+}
+
+// writeManifestMarker tags the manifest in the flatpak folder as created by browser-glue.
+func (config *NativeConfigFile) writeManifestMarker() error {
+	data, err := os.ReadFile(config.flatpakConfigPath())
+	if err != nil {
+		return err
+	}
+	return util.ReplaceFile(config.manifestMarkerPath(), []byte(manifestChecksum(data)+"\n"), 0o600)
+}
+
+func (config *NativeConfigFile) removeManifestMarker() {
+	err := os.Remove(config.manifestMarkerPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logs.Warn("could not remove marker of", config.Name(), err)
+	}
+}
+
+// manifestMarkerPath is next to the manifest, browsers only read files named after the host.
+func (config *NativeConfigFile) manifestMarkerPath() string {
+	flatpakPath := config.flatpakConfigPath()
+	return filepath.Join(filepath.Dir(flatpakPath), "."+filepath.Base(flatpakPath)+".browser-glue")
+}
+
+func (config *NativeConfigFile) manifestBackupPath() string {
+	return filepath.Join(util.GetCustomUserDataDir(), "manifest-backups", config.browser.GetFlatpakId(), config.Name())
+}
+
+func manifestChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
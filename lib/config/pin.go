@@ -0,0 +1,222 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
+)
+
+var ErrExecutableChanged = errors.New("host executable changed since it was approved")
+
+// ExecutableStatus tells if the executable of a host still matches the one the user approved.
+type ExecutableStatus string
+
+const (
+	ExecutableApproved  ExecutableStatus = "approved"
+	ExecutableChanged   ExecutableStatus = "changed"
+	ExecutableNotPinned ExecutableStatus = "not pinned"
+	// ExecutableUnverifiable is used for hosts inside a flatpak or a container, their executable is not on this system.
+	ExecutableUnverifiable ExecutableStatus = "unverifiable"
+)
+
+// executablePin identifies the contents and the owner of an executable.
+type executablePin struct {
+	sha256 string
+	size   int64
+	owner  int
+}
+
+// PinExecutable records the current executable of the host as approved.
+func (config *NativeConfigFile) PinExecutable() error {
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
+
+	pin, err := openExecutablePin(config.Content.Executable)
+	if errors.Is(err, os.ErrNotExist) && config.executableOutsideOfHost(appSettings) {
+		logs.Debug("not pinning", config.Content.Executable, "because it is not on this system")
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("could not pin executable of %s: %w", config.Name(), err)
+		logs.Error(err)
+		return err
+	}
+
+	appSettings.ExecutableSHA256 = pin.sha256
+	appSettings.ExecutableSize = pin.size
+	appSettings.ExecutableOwner = pin.owner
+	err = settings.SetAppSettings(config.browser, appSettings)
+	if err != nil {
+		err = fmt.Errorf("could not save pinned executable of %s: %w", config.Name(), err)
+		logs.Error(err)
+		return err
+	}
+	logs.Info("pinned executable", config.Content.Executable, "sha256:", pin.sha256)
+	return nil
+}
+
+// VerifyExecutable compares the executable of the host with the pinned one.
+// The error describes the difference if the status is ExecutableChanged.
+func (config *NativeConfigFile) VerifyExecutable() (ExecutableStatus, error) {
+	executable, status, err := config.OpenExecutable()
+	if executable != nil {
+		executable.Close()
+	}
+	return status, err
+}
+
+// VerifiedExecutable is an open executable that matched the pinned one when it was opened.
+// It stays open until the host is started, so Unchanged can compare the file at the path with the verified one.
+type VerifiedExecutable struct {
+	file   *os.File
+	stamp  fileStamp
+	SHA256 string
+}
+
+// Unchanged returns ErrExecutableChanged if the path leads to another file than the verified one
+// or the file was written to since it was verified.
+func (executable *VerifiedExecutable) Unchanged() error {
+	path := executable.file.Name()
+	for _, stat := range []func() (os.FileInfo, error){executable.file.Stat, func() (os.FileInfo, error) { return os.Stat(path) }} {
+		info, err := stat()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrExecutableChanged, err)
+		}
+		stamp, err := stampOf(info)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrExecutableChanged, err)
+		}
+		if stamp != executable.stamp {
+			return fmt.Errorf("%w: %s was replaced or written to after it was verified", ErrExecutableChanged, path)
+		}
+	}
+	return nil
+}
+
+func (executable *VerifiedExecutable) Close() error {
+	return executable.file.Close()
+}
+
+// OpenExecutable opens the executable of the host and compares it with the pinned one.
+// The executable is only returned if the status is ExecutableApproved, the caller has to close its file.
+func (config *NativeConfigFile) OpenExecutable() (*VerifiedExecutable, ExecutableStatus, error) {
+	appSettings := settings.GetAppSettings(config.browser, config.Name())
+
+	file, err := os.Open(config.Content.Executable)
+	if errors.Is(err, os.ErrNotExist) && config.executableOutsideOfHost(appSettings) {
+		return nil, ExecutableUnverifiable, nil
+	}
+	if appSettings.ExecutableSHA256 == "" {
+		if file != nil {
+			file.Close()
+		}
+		return nil, ExecutableNotPinned, nil
+	}
+	if err != nil {
+		return nil, ExecutableChanged, fmt.Errorf("%w: %w", ErrExecutableChanged, err)
+	}
+
+	pin, stamp, err := readExecutablePin(file)
+	if err != nil {
+		file.Close()
+		return nil, ExecutableChanged, fmt.Errorf("%w: %w", ErrExecutableChanged, err)
+	}
+
+	switch {
+	case pin.sha256 != appSettings.ExecutableSHA256:
+		err = fmt.Errorf("%w: sha256 is %s instead of %s", ErrExecutableChanged, pin.sha256, appSettings.ExecutableSHA256)
+	case pin.size != appSettings.ExecutableSize:
+		err = fmt.Errorf("%w: size is %d instead of %d", ErrExecutableChanged, pin.size, appSettings.ExecutableSize)
+	case pin.owner != appSettings.ExecutableOwner:
+		err = fmt.Errorf("%w: owner is %d instead of %d", ErrExecutableChanged, pin.owner, appSettings.ExecutableOwner)
+	}
+	if err != nil {
+		file.Close()
+		return nil, ExecutableChanged, err
+	}
+	return &VerifiedExecutable{file: file, stamp: stamp, SHA256: pin.sha256}, ExecutableApproved, nil
+}
+
+// executableOutsideOfHost reports if the executable is expected to be missing on this system.
+func (config *NativeConfigFile) executableOutsideOfHost(appSettings settings.AppSettings) bool {
+	return config.FlatpakHostId != "" || appSettings.Wrapper != ""
+}
+
+// fileStamp changes whenever a file is replaced or written to.
+type fileStamp struct {
+	device  uint64
+	inode   uint64
+	size    int64
+	modTime int64
+	change  syscall.Timespec
+}
+
+type cachedPin struct {
+	stamp fileStamp
+	pin   executablePin
+}
+
+// pinCache avoids hashing executables again for every connection.
+var pinCache = map[string]cachedPin{}
+var pinCacheMutex sync.Mutex
+
+// openExecutablePin reads the pin of the executable at path.
+func openExecutablePin(path string) (executablePin, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return executablePin{}, err
+	}
+	defer file.Close()
+	pin, _, err := readExecutablePin(file)
+	return pin, err
+}
+
+// readExecutablePin also returns the stamp of the file the pin was read from.
+func readExecutablePin(file *os.File) (executablePin, fileStamp, error) {
+	path := file.Name()
+	info, err := file.Stat()
+	if err != nil {
+		return executablePin{}, fileStamp{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return executablePin{}, fileStamp{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	stamp, err := stampOf(info)
+	if err != nil {
+		return executablePin{}, fileStamp{}, err
+	}
+
+	pinCacheMutex.Lock()
+	cached, found := pinCache[path]
+	pinCacheMutex.Unlock()
+	if found && cached.stamp == stamp {
+		return cached.pin, stamp, nil
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return executablePin{}, fileStamp{}, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	pin := executablePin{sha256: hex.EncodeToString(hash.Sum(nil)), size: size, owner: int(info.Sys().(*syscall.Stat_t).Uid)}
+
+	pinCacheMutex.Lock()
+	pinCache[path] = cachedPin{stamp: stamp, pin: pin}
+	pinCacheMutex.Unlock()
+	return pin, stamp, nil
+}
+
+func stampOf(info os.FileInfo) (fileStamp, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStamp{}, fmt.Errorf("can't read the owner of %s", info.Name())
+	}
+	return fileStamp{device: uint64(stat.Dev), inode: stat.Ino, size: info.Size(), modTime: info.ModTime().UnixNano(), change: stat.Ctim}, nil
+}
@@ -0,0 +1,137 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatJournald = "journald"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// Config selects how and where messages are written.
+type Config struct {
+	// Format is FormatText, FormatJSON or FormatJournald, empty uses FormatJournald when running as a systemd service.
+	Format string
+	// Level is the lowest level that is written, 0 keeps the current one.
+	Level LogLevel
+	// File additionally writes messages to GetLogFilePath in the same format without colors.
+	File bool
+	// Color is ColorAuto, ColorAlways or ColorNever, ColorAuto only uses colors on terminals.
+	Color string
+}
+
+// Configure replaces the outputs of all loggers, including the ones created before.
+func Configure(config Config) error {
+	format := config.Format
+	if format == "" {
+		format = FormatText
+		// systemd sets JOURNAL_STREAM when the output goes to the journal
+		if os.Getenv("JOURNAL_STREAM") != "" {
+			format = FormatJournald
+		}
+	}
+
+	switch config.Color {
+	case "", ColorAuto:
+		if isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" {
+			pterm.EnableColor()
+		} else {
+			pterm.DisableColor()
+		}
+	case ColorAlways:
+		pterm.EnableColor()
+	case ColorNever:
+		pterm.DisableColor()
+	default:
+		return fmt.Errorf("unknown color mode %q, use %s, %s or %s", config.Color, ColorAuto, ColorAlways, ColorNever)
+	}
+
+	handlers := multiHandler{}
+	terminal, err := newHandler(format, os.Stdout, true)
+	if err != nil {
+		return err
+	}
+	handlers = append(handlers, terminal)
+
+	if config.File {
+		logFile, err := openRotatingFile(GetLogFilePath())
+		if err != nil {
+			return fmt.Errorf("can't open log file %s: %w", GetLogFilePath(), err)
+		}
+		file, err := newHandler(format, logFile, false)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, file)
+	}
+
+	if config.Level != 0 {
+		SetLogLevel(config.Level)
+	}
+	if len(handlers) == 1 {
+		setHandler(handlers[0])
+	} else {
+		setHandler(handlers)
+	}
+	return nil
+}
+
+// newHandler only prints with pterm to the terminal, files get plain key value pairs in the text format.
+func newHandler(format string, writer io.Writer, terminal bool) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: logLevel, AddSource: true}
+	switch format {
+	case FormatText:
+		if terminal {
+			return newTerminalHandler(), nil
+		}
+		return slog.NewTextHandler(writer, options), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(writer, options), nil
+	case FormatJournald:
+		return newJournaldHandler(writer), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use %s, %s or %s", format, FormatText, FormatJSON, FormatJournald)
+	}
+}
+
+// ParseLogLevel accepts the names of the levels like "debug" or "warn".
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+}
+
+// GetLogFilePath returns the log file of browser-glue, rotated files get a number appended.
+func GetLogFilePath() string {
+	return filepath.Join(util.GetCustomUserStateDir(), "browser-glue.log")
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
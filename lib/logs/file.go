@@ -0,0 +1,119 @@
+package logs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// MaxLogFileSize is the size after which the log file is rotated.
+const MaxLogFileSize = 8 * 1024 * 1024
+
+// RotatedLogFiles is the number of rotated log files that are kept.
+const RotatedLogFiles = 3
+
+// rotatingFile starts a new file once the current one reaches MaxLogFileSize.
+// Other processes might write to the same file, so its size is checked again before rotating.
+type rotatingFile struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+	size  int64
+}
+
+func openRotatingFile(path string) (*rotatingFile, error) {
+	err := util.MkdirSecure(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, err
+	}
+	rotating := &rotatingFile{path: path}
+	err = rotating.open()
+	if err != nil {
+		return nil, err
+	}
+	return rotating, nil
+}
+
+func (rotating *rotatingFile) Write(p []byte) (int, error) {
+	rotating.mutex.Lock()
+	defer rotating.mutex.Unlock()
+
+	if rotating.size+int64(len(p)) > MaxLogFileSize {
+		rotating.rotate()
+	}
+
+	n, err := rotating.file.Write(p)
+	rotating.size += int64(n)
+	return n, err
+}
+
+func (rotating *rotatingFile) open() error {
+	file, err := util.OpenAppend(rotating.path, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotating.file = file
+	rotating.size = info.Size()
+	return nil
+}
+
+// rotate keeps writing to the current file if a new one can't be opened.
+// If the file can't be moved, the next try is after another MaxLogFileSize bytes.
+func (rotating *rotatingFile) rotate() {
+	info, err := os.Lstat(rotating.path)
+	// another process already rotated
+	if err == nil && info.Size() < MaxLogFileSize && !os.SameFile(info, rotating.stat()) {
+		rotating.reopen()
+		return
+	}
+
+	for number := RotatedLogFiles - 1; number >= 1; number-- {
+		err := os.Rename(rotating.rotatedPath(number), rotating.rotatedPath(number+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			warnInBackground("could not rotate log file", rotating.rotatedPath(number), err)
+		}
+	}
+	err = os.Rename(rotating.path, rotating.rotatedPath(1))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnInBackground("could not rotate log file", rotating.path, err)
+		rotating.size = 0
+		return
+	}
+	rotating.reopen()
+}
+
+// warnInBackground is used while a log message is written, logging right away would wait for the write to finish.
+func warnInBackground(v ...any) {
+	go Warn(v...)
+}
+
+func (rotating *rotatingFile) reopen() {
+	oldFile := rotating.file
+	err := rotating.open()
+	if err != nil {
+		rotating.file = oldFile
+		return
+	}
+	oldFile.Close()
+}
+
+func (rotating *rotatingFile) stat() os.FileInfo {
+	info, err := rotating.file.Stat()
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+func (rotating *rotatingFile) rotatedPath(number int) string {
+	return rotating.path + "." + strconv.Itoa(number)
+}
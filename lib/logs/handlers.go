@@ -0,0 +1,181 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// terminalHandler prints messages for humans with pterm, warnings and errors include the caller.
+type terminalHandler struct {
+	logger *pterm.Logger
+	attrs  []slog.Attr
+	group  string
+}
+
+func newTerminalHandler() slog.Handler {
+	return &terminalHandler{logger: pterm.DefaultLogger.WithLevel(pterm.LogLevelTrace)}
+}
+
+func (h *terminalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h *terminalHandler) Handle(ctx context.Context, record slog.Record) error {
+	args := []pterm.LoggerArgument{}
+	for _, attr := range h.attrs {
+		args = append(args, pterm.LoggerArgument{Key: attr.Key, Value: attr.Value.Resolve().Any()})
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		args = append(args, pterm.LoggerArgument{Key: h.group + attr.Key, Value: attr.Value.Resolve().Any()})
+		return true
+	})
+	if record.Level >= slog.LevelWarn && record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		args = append(args, pterm.LoggerArgument{Key: "caller", Value: pterm.FgGray.Sprintf("%s:%d", frame.File, frame.Line)})
+	}
+
+	switch {
+	case record.Level >= slog.LevelError:
+		h.logger.Error(record.Message, args)
+	case record.Level >= slog.LevelWarn:
+		h.logger.Warn(record.Message, args)
+	case record.Level >= slog.LevelInfo:
+		h.logger.Info(record.Message, args)
+	default:
+		h.logger.Debug(record.Message, args)
+	}
+	return nil
+}
+
+func (h *terminalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	newHandler := *h
+	newHandler.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.group + attr.Key
+		newHandler.attrs = append(newHandler.attrs, attr)
+	}
+	return &newHandler
+}
+
+func (h *terminalHandler) WithGroup(name string) slog.Handler {
+	newHandler := *h
+	newHandler.group = h.group + name + "."
+	return &newHandler
+}
+
+// journaldHandler prefixes every line with its syslog priority, which journald reads from the output of services.
+// journald adds its own timestamps, so the time is left out.
+type journaldHandler struct {
+	writer io.Writer
+	mutex  *sync.Mutex
+	// changes are applied to the text handler of every record
+	changes []func(slog.Handler) slog.Handler
+}
+
+func newJournaldHandler(writer io.Writer) slog.Handler {
+	return &journaldHandler{writer: writer, mutex: &sync.Mutex{}}
+}
+
+func (h *journaldHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h *journaldHandler) Handle(ctx context.Context, record slog.Record) error {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "<%d>", journaldPriority(record.Level))
+
+	var textHandler slog.Handler = slog.NewTextHandler(buffer, &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	for _, change := range h.changes {
+		textHandler = change(textHandler)
+	}
+	err := textHandler.Handle(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err = h.writer.Write(buffer.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withChange(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	return h.withChange(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *journaldHandler) withChange(change func(slog.Handler) slog.Handler) slog.Handler {
+	newHandler := *h
+	newHandler.changes = append(append([]func(slog.Handler) slog.Handler{}, h.changes...), change)
+	return &newHandler
+}
+
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// multiHandler sends records to the terminal and the log file.
+type multiHandler []slog.Handler
+
+func (handlers multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (handlers multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	errs := []error{}
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (handlers multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	newHandlers := multiHandler{}
+	for _, handler := range handlers {
+		newHandlers = append(newHandlers, handler.WithAttrs(attrs))
+	}
+	return newHandlers
+}
+
+func (handlers multiHandler) WithGroup(name string) slog.Handler {
+	newHandlers := multiHandler{}
+	for _, handler := range handlers {
+		newHandlers = append(newHandlers, handler.WithGroup(name))
+	}
+	return newHandlers
+}
@@ -1,9 +1,13 @@
 package logs
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/pterm/pterm"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 type LogLevel int
@@ -15,37 +19,111 @@ const (
 	ErrorLevel LogLevel = 5
 )
 
-var logger *pterm.Logger
+// Logger adds key value pairs to every message, like the browser or extension a message is about.
+type Logger struct {
+	attrs []slog.Attr
+}
+
+// With returns a logger that adds the key value pairs to its messages.
+func With(args ...any) *Logger {
+	return defaultLogger.With(args...)
+}
+
+// With returns a logger with the key value pairs of both.
+func (logger *Logger) With(args ...any) *Logger {
+	record := slog.Record{}
+	record.Add(args...)
+	attrs := append([]slog.Attr{}, logger.attrs...)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	return &Logger{attrs: attrs}
+}
+
+func (logger *Logger) Debug(v ...any) {
+	logger.log(slog.LevelDebug, v)
+}
+
+func (logger *Logger) Info(v ...any) {
+	logger.log(slog.LevelInfo, v)
+}
+
+func (logger *Logger) Warn(v ...any) {
+	logger.log(slog.LevelWarn, v)
+}
+
+func (logger *Logger) Error(v ...any) {
+	logger.log(slog.LevelError, v)
+}
+
+// log formats the values like fmt.Sprintln, the caller of the exported function is recorded as the source.
+func (logger *Logger) log(level slog.Level, v []any) {
+	handler := currentHandler()
+	if !handler.Enabled(context.Background(), level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	message := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	record := slog.NewRecord(time.Now(), level, message, pcs[0])
+	record.AddAttrs(logger.attrs...)
+	handler.Handle(context.Background(), record)
+}
+
+var defaultLogger = &Logger{}
 
 func Debug(v ...any) {
-	logger.Debug(fmt.Sprintln(v...))
+	defaultLogger.log(slog.LevelDebug, v)
 }
 
 func Info(v ...any) {
-	logger.Info(fmt.Sprintln(v...))
+	defaultLogger.log(slog.LevelInfo, v)
 }
 
 func Warn(v ...any) {
-	logger.WithCaller().WithCallerOffset(1).Warn(fmt.Sprintln(v...))
+	defaultLogger.log(slog.LevelWarn, v)
 }
 
 func Error(v ...any) {
-	logger.WithCaller().WithCallerOffset(1).Error(fmt.Sprintln(v...))
+	defaultLogger.log(slog.LevelError, v)
 }
 
 func SetLogLevel(level LogLevel) {
 	switch level {
 	case DebugLevel:
-		logger.Level = pterm.LogLevelDebug
+		logLevel.Set(slog.LevelDebug)
 	case InfoLevel:
-		logger.Level = pterm.LogLevelInfo
+		logLevel.Set(slog.LevelInfo)
 	case WarnLevel:
-		logger.Level = pterm.LogLevelWarn
+		logLevel.Set(slog.LevelWarn)
 	case ErrorLevel:
-		logger.Level = pterm.LogLevelError
+		logLevel.Set(slog.LevelError)
 	}
 }
 
+// logLevel is shared by all handlers, so changing it doesn't require configuring the output again.
+var logLevel = &slog.LevelVar{}
+
+var handler struct {
+	sync.RWMutex
+	current slog.Handler
+}
+
+func currentHandler() slog.Handler {
+	handler.RLock()
+	defer handler.RUnlock()
+	return handler.current
+}
+
+func setHandler(newHandler slog.Handler) {
+	handler.Lock()
+	defer handler.Unlock()
+	handler.current = newHandler
+}
+
 func init() {
-	logger = pterm.DefaultLogger.WithLevel(pterm.LogLevelTrace)
+	logLevel.Set(slog.LevelDebug)
+	setHandler(newTerminalHandler())
 }
@@ -0,0 +1,100 @@
+package protocol
+
+// don't use any packages from this repo or otherwise not in the stdlib
+// this gets included in the client so it needs to be as small as possible
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version has to be increased whenever client and server can't understand each other anymore.
+const Version = 1
+
+// MaxFrameSize limits frames exchanged between client and server, native messaging data is not affected.
+const MaxFrameSize = 64 * 1024
+
+// ServerHelloTimeout is how long the client waits for the ServerHello.
+// The server can ask the user to approve the extension before answering, so it is longer than an approval prompt stays open.
+const ServerHelloTimeout = 3 * time.Minute
+
+// ClientHello is sent by the client right after connecting.
+type ClientHello struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Kind            Kind   `json:"kind,omitempty"`
+	ClientVersion   string `json:"client_version"`
+	Browser         string `json:"browser"`
+	HostName        string `json:"host_name"`
+	Extension       string `json:"extension"`
+	ManifestPath    string `json:"manifest_path,omitempty"`
+	PID             int    `json:"pid"`
+	// Args are the arguments the browser called the client with.
+	// The server only passes flags in the allow list of util.ParseBrowserArguments on to the host and logs the others.
+	Args []string `json:"args"`
+}
+
+type Kind string
+
+const (
+	// ConnectKind connects the client to a newly started host.
+	ConnectKind Kind = ""
+	// PingKind only checks that the server answers, the connection is closed after the ServerHello.
+	PingKind Kind = "ping"
+)
+
+// MultiplexSocketName is the name of the socket that serves all hosts of a browser if the server runs in multiplex mode.
+const MultiplexSocketName = "multiplexed"
+
+// ServerHello answers a ClientHello, if Error is empty everything after it is native messaging data.
+type ServerHello struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	ServerVersion   string `json:"server_version"`
+	Error           string `json:"error,omitempty"`
+	// HostName and Extension are only sent as an answer to a ping.
+	HostName  string `json:"host_name,omitempty"`
+	Extension string `json:"extension,omitempty"`
+}
+
+// ErrorMessage is sent to the extension as a native message when the client can't connect it to the host.
+type ErrorMessage struct {
+	Error string `json:"error"`
+}
+
+// WriteFrame writes a value as JSON prefixed by its length, the same way native messages are encoded.
+func WriteFrame(writer io.Writer, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4, 4+len(data))
+	binary.NativeEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+
+	_, err = writer.Write(frame)
+	return err
+}
+
+// ReadFrame reads a frame written by WriteFrame without reading past its end.
+func ReadFrame(reader io.Reader, value any) error {
+	sizeEncoded := make([]byte, 4)
+	_, err := io.ReadFull(reader, sizeEncoded)
+	if err != nil {
+		return err
+	}
+
+	size := binary.NativeEndian.Uint32(sizeEncoded)
+	if size > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/approval"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
+)
+
+var ErrExtensionDenied = errors.New("the extension is not allowed to use this host")
+
+// approvalTimeout is how long the user has to answer an approval prompt.
+// The client waits for the handshake meanwhile, so it has to stay below protocol.ServerHelloTimeout.
+const approvalTimeout = 2 * time.Minute
+
+// promptSlot makes sure the user only sees one prompt at a time,
+// connections waiting for it see the decision of the previous prompt.
+// Unlike a mutex, waiting for it can be canceled when the server stops.
+var promptSlot = make(chan struct{}, 1)
+
+// approveExtension asks the user the first time an extension connects if approval prompts are enabled.
+// Canceling ctx stops waiting for other prompts and closes the prompt of this connection.
+func (serv *Server) approveExtension(ctx context.Context, log *logs.Logger) error {
+	if !settings.ApprovalPromptsEnabled() {
+		return nil
+	}
+
+	decided, err := serv.rememberedDecision()
+	if decided {
+		return err
+	}
+
+	select {
+	case promptSlot <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("stopped waiting to ask about %s: %w", serv.ExtensionName, context.Cause(ctx))
+	}
+	defer func() { <-promptSlot }()
+
+	decided, err = serv.rememberedDecision()
+	if decided {
+		return err
+	}
+
+	request := approval.Request{
+		Browser:    string(serv.ConfigFile.GetBrowser()),
+		HostName:   serv.ConfigFile.Content.Name,
+		AppName:    serv.ConfigFile.Name(),
+		Extension:  serv.ExtensionName,
+		Executable: serv.ConfigFile.Content.Executable,
+	}
+	promptCtx, cancel := context.WithTimeout(ctx, approvalTimeout)
+	defer cancel()
+
+	log.Info("asking the user to approve", serv.ExtensionName, "for", serv.ConfigFile.Name())
+	decision, approverName, err := approval.Ask(promptCtx, request, settings.Approvers())
+	if ctx.Err() != nil {
+		return fmt.Errorf("stopped asking about %s: %w", serv.ExtensionName, context.Cause(ctx))
+	}
+	if err != nil {
+		return fmt.Errorf("could not get approval for %s: %w", serv.ExtensionName, err)
+	}
+	log.Info("approver", approverName, "answered", decision, "for", serv.ExtensionName)
+
+	// policy rules are applied every time, so changing the policy file takes effect
+	if approverName != approval.PolicyApprover && decision != approval.AllowOnce {
+		serv.rememberDecision(log, decision)
+	}
+
+	if decision == approval.Deny {
+		return ErrExtensionDenied
+	}
+	return nil
+}
+
+// rememberedDecision reports if the user already decided about the extension and returns an error if it was denied.
+func (serv *Server) rememberedDecision() (bool, error) {
+	appSettings := settings.GetAppSettings(serv.ConfigFile.GetBrowser(), serv.ConfigFile.Name())
+	switch appSettings.GetExtensionDecision(serv.ExtensionName) {
+	case settings.DecisionAllow:
+		return true, nil
+	case settings.DecisionDeny:
+		return true, fmt.Errorf("%w, revoke the decision with \"browser-glue apps revoke %s\"", ErrExtensionDenied, serv.ConfigFile.Name())
+	default:
+		return false, nil
+	}
+}
+
+func (serv *Server) rememberDecision(log *logs.Logger, decision approval.Decision) {
+	browser := serv.ConfigFile.GetBrowser()
+	appSettings := settings.GetAppSettings(browser, serv.ConfigFile.Name())
+
+	remembered := settings.DecisionAllow
+	if decision == approval.Deny {
+		remembered = settings.DecisionDeny
+	}
+	appSettings.SetExtensionDecision(serv.ExtensionName, remembered)
+
+	err := settings.SetAppSettings(browser, appSettings)
+	if err != nil {
+		log.Error(fmt.Errorf("could not remember decision about %s: %w", serv.ExtensionName, err))
+	}
+}
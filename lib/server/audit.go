@@ -0,0 +1,39 @@
+package server
+
+import (
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/audit"
+)
+
+// auditLaunched records in the audit log that a host is about to be started or a warm host is handed to a connection.
+// executableSHA256 is the checksum the executable was verified with before the launch.
+func (serv *Server) auditLaunched(peerPid int, executableSHA256 string) {
+	audit.Append(serv.auditRecord(audit.EventLaunched, peerPid, executableSHA256))
+}
+
+// auditEnded records how a launch ended, host is nil if it could not be started.
+func (serv *Server) auditEnded(peerPid int, connectedSince time.Time, host *hostProcess, executableSHA256 string, reason string) {
+	record := serv.auditRecord(audit.EventEnded, peerPid, executableSHA256)
+	record.DurationMs = time.Since(connectedSince).Milliseconds()
+	record.ExitStatus = "not started"
+	if host != nil {
+		record.ExitStatus = host.exitDescription()
+	}
+	record.Reason = reason
+	audit.Append(record)
+}
+
+func (serv *Server) auditRecord(event string, peerPid int, executableSHA256 string) audit.Record {
+	return audit.Record{
+		Time:       time.Now(),
+		Event:      event,
+		Browser:    string(serv.ConfigFile.GetBrowser()),
+		Extension:  serv.ExtensionName,
+		HostName:   serv.ConfigFile.Content.Name,
+		App:        serv.ConfigFile.Name(),
+		Executable: serv.ConfigFile.Content.Executable,
+		SHA256:     executableSHA256,
+		PeerPID:    peerPid,
+	}
+}
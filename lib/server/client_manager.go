@@ -1,7 +1,12 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
 	_ "embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,9 +14,10 @@ import (
 	"sync"
 
 	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
 )
 
-//go:generate go build -o generated/client-executable ../../client/client.go
+//go:generate go build -o generated/client-executable ../../client
 //go:embed generated/client-executable
 var clientExecutableData []byte
 
@@ -22,47 +28,217 @@ type alreadyCreatedListSafe struct {
 
 var alreadyCreated alreadyCreatedListSafe
 
-func writeClientExecutable(clientExecutablePath string) error {
+// ClientInfo describes a client executable.
+type ClientInfo struct {
+	Path     string
+	Version  string
+	Checksum string
+}
+
+// GetEmbeddedClientInfo describes the client this program deploys.
+func GetEmbeddedClientInfo() ClientInfo {
+	embeddedClientInfoOnce.Do(func() {
+		embeddedClientInfo.Checksum = checksum(clientExecutableData)
+		embeddedClientInfo.Version = "unknown"
+		info, err := buildinfo.Read(bytes.NewReader(clientExecutableData))
+		if err == nil {
+			embeddedClientInfo.Version = util.VersionFromBuildInfo(info)
+		}
+	})
+	return embeddedClientInfo
+}
+
+var embeddedClientInfo ClientInfo
+var embeddedClientInfoOnce sync.Once
+
+// GetDeployedClientInfo describes the client that the browser currently uses.
+// It returns an error wrapping os.ErrNotExist if the client is not deployed.
+func GetDeployedClientInfo(browser util.Browser) (ClientInfo, error) {
+	clientInfo := ClientInfo{Path: browser.GetClientPath(), Version: "unknown"}
+
+	data, err := os.ReadFile(clientInfo.Path)
+	if err != nil {
+		return clientInfo, err
+	}
+	clientInfo.Checksum = checksum(data)
+
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err == nil {
+		clientInfo.Version = util.VersionFromBuildInfo(info)
+	}
+
+	return clientInfo, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeClientExecutable only replaces the client if it differs from the embedded one.
+// The new client is moved into place, so browsers that are currently executing the old one are not affected.
+func writeClientExecutable(browser util.Browser) error {
 	var err error
 
+	clientExecutablePath := browser.GetClientPath()
+	log := logs.With("browser", string(browser))
+
 	alreadyCreated.Lock()
+	defer alreadyCreated.Unlock()
 	if slices.Contains(alreadyCreated.list, clientExecutablePath) {
-		alreadyCreated.Unlock()
 		return nil
 	}
-	alreadyCreated.list = append(alreadyCreated.list, clientExecutablePath)
-	alreadyCreated.Unlock()
 
-	err = os.MkdirAll(filepath.Dir(clientExecutablePath), 0o755)
+	embeddedInfo := GetEmbeddedClientInfo()
+
+	clientFolder := filepath.Dir(clientExecutablePath)
+	err = util.MkdirSecure(clientFolder, 0o700)
 	if err != nil {
 		err = fmt.Errorf("can't create directory for client executable: %w", err)
-		logs.Error(err)
+		log.Error(err)
+		return err
+	}
+	// the browser executes the client, so it must not be replaced by something of another user
+	err = util.CheckReplaceable(clientExecutablePath)
+	if err != nil {
+		err = fmt.Errorf("can't deploy client executable: %w", err)
+		log.Error(err)
 		return err
 	}
 
-	file, err := os.Create(clientExecutablePath)
+	deployedInfo, err := GetDeployedClientInfo(browser)
+	if err == nil && deployedInfo.Checksum == embeddedInfo.Checksum {
+		log.Debug("client executable in", clientExecutablePath, "is up to date")
+		alreadyCreated.list = append(alreadyCreated.list, clientExecutablePath)
+		return nil
+	}
+	if err == nil {
+		log.Info("replacing outdated client version", deployedInfo.Version, "with version", embeddedInfo.Version, "in", clientExecutablePath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Warn("could not check the existing client executable, replacing it:", err)
+	}
+
+	file, err := os.CreateTemp(clientFolder, ".client-*")
 	if err != nil {
 		err = fmt.Errorf("can't create client executable file: %w", err)
-		logs.Error(err)
+		log.Error(err)
 		return err
 	}
+	defer os.Remove(file.Name())
 	defer file.Close()
 
-	err = file.Chmod(0o755)
+	err = file.Chmod(0o700)
 	if err != nil {
 		err = fmt.Errorf("can't change permissions for client executable file: %w", err)
-		logs.Error(err)
+		log.Error(err)
 		return err
 	}
 
 	_, err = file.Write(clientExecutableData)
+	if err == nil {
+		err = file.Sync()
+	}
 	if err != nil {
 		err = fmt.Errorf("can't write client executable: %w", err)
-		logs.Error(err)
+		log.Error(err)
+		return err
+	}
+
+	err = os.Rename(file.Name(), clientExecutablePath)
+	if err != nil {
+		err = fmt.Errorf("can't move client executable into place at %s: %w", clientExecutablePath, err)
+		log.Error(err)
 		return err
 	}
 
-	logs.Info("client executable created in:", clientExecutablePath)
+	alreadyCreated.list = append(alreadyCreated.list, clientExecutablePath)
+	log.Info("client executable version", embeddedInfo.Version, "created in:", clientExecutablePath)
+
+	return nil
+}
+
+// writeHostClientLink creates the path the flatpak side config points to for a host.
+func writeHostClientLink(browser util.Browser, hostName string) error {
+	linkPath := browser.GetHostClientPath(hostName)
+	log := logs.With("browser", string(browser))
+	linkTarget, err := filepath.Rel(filepath.Dir(linkPath), browser.GetClientPath())
+	if err != nil {
+		err = fmt.Errorf("can't find relative client path for %s: %w", hostName, err)
+		log.Error(err)
+		return err
+	}
+
+	err = util.MkdirSecure(filepath.Dir(linkPath), 0o700)
+	if err != nil {
+		err = fmt.Errorf("can't create directory for host links: %w", err)
+		log.Error(err)
+		return err
+	}
+
+	info, err := os.Lstat(linkPath)
+	if err == nil {
+		// only links are replaced, anything else was not created by browser-glue
+		if info.Mode()&os.ModeSymlink == 0 {
+			err = fmt.Errorf("host link %s is not a symbolic link: %w", linkPath, util.ErrTampered)
+			log.Error(err)
+			return err
+		}
+		existingTarget, err := os.Readlink(linkPath)
+		if err == nil && existingTarget == linkTarget {
+			return nil
+		}
+		err = os.Remove(linkPath)
+		if err != nil {
+			err = fmt.Errorf("can't remove old host link %s: %w", linkPath, err)
+			log.Error(err)
+			return err
+		}
+	}
+
+	// Symlink fails if something was created at the path in the meantime
+	err = os.Symlink(linkTarget, linkPath)
+	if err != nil {
+		err = fmt.Errorf("can't create host link %s: %w", linkPath, err)
+		log.Error(err)
+		return err
+	}
 
 	return nil
 }
+
+type cleanedUpListSafe struct {
+	sync.Mutex
+	list []util.Browser
+}
+
+var legacySocketsCleanedUp cleanedUpListSafe
+
+// removeLegacySockets deletes sockets of older versions, which were placed directly in the runtime app folder.
+func removeLegacySockets(browser util.Browser) {
+	legacySocketsCleanedUp.Lock()
+	defer legacySocketsCleanedUp.Unlock()
+	if slices.Contains(legacySocketsCleanedUp.list, browser) {
+		return
+	}
+	legacySocketsCleanedUp.list = append(legacySocketsCleanedUp.list, browser)
+
+	runtimeAppFolder := browser.GetFlatpakRuntimeAppFolder()
+	log := logs.With("browser", string(browser))
+	entries, err := os.ReadDir(runtimeAppFolder)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		socketPath := filepath.Join(runtimeAppFolder, entry.Name())
+		err = os.Remove(socketPath)
+		if err != nil {
+			log.Warn("could not remove old socket", socketPath, err)
+			continue
+		}
+		log.Debug("removed old socket", socketPath)
+	}
+}
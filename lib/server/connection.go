@@ -1,22 +1,35 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os/exec"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pterm/pterm"
-	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/protocol"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
-func handleConnection(commandPath string, configPath string, extensionName string, browser util.Browser, listenIn bool, conn net.Conn, stop chan bool, wg *sync.WaitGroup) error {
-	defer logs.Debug("connection exited", extensionName)
+// nextConnectionID numbers the connections so the messages of one connection can be found in the logs.
+var nextConnectionID atomic.Uint64
+
+// handleConnection reads the hello of the client itself if it is nil.
+// ctx is canceled when the server stops, stop only reaches connections that finished their handshake.
+func handleConnection(ctx context.Context, serv *Server, conn net.Conn, hello *protocol.ClientHello, stop chan bool, wg *sync.WaitGroup) error {
+	configPath := serv.ConfigFile.Path
+	extensionName := serv.ExtensionName
+	log := serv.logger().With("connection", nextConnectionID.Add(1))
+
+	defer log.Debug("connection exited", extensionName)
 
 	wg.Add(1)
 	defer wg.Done()
@@ -27,75 +40,198 @@ func handleConnection(commandPath string, configPath string, extensionName strin
 	var err error
 	defer conn.Close()
 
-	logs.Info("new connection for", extensionName)
+	log.Info("new connection for", extensionName)
 
-	var cmd *exec.Cmd
-	switch browser {
-	case util.Firefox, util.Floorp:
-		cmd = exec.Command(commandPath, configPath, extensionName)
-	case util.Chromium, util.Brave:
-		cmd = exec.Command(commandPath, extensionName)
+	if hello == nil {
+		readHello, err := readClientHello(conn)
+		if err != nil {
+			err = fmt.Errorf("handshake for %s failed: %w", extensionName, err)
+			log.Error(err)
+			return err
+		}
+		hello = &readHello
 	}
-	defer cmd.Wait()
-
-	cmd.Dir = filepath.Dir(commandPath)
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		err = fmt.Errorf("could not open the Stdin pipe for %s: %w", extensionName, err)
-		logs.Error(err)
+	appSettings := serv.loadAppSettings(log)
+
+	slotAcquired := false
+	peerPid := 0
+	var host *hostProcess
+	var executable *config.VerifiedExecutable
+	// the checksum is taken when the executable is verified, the file might change while the host runs
+	executableSHA256 := ""
+	verifyPeer := func() error {
+		var err error
+		peerPid, err = serv.verifyPeer(log, conn, appSettings)
 		return err
 	}
-	defer stdin.Close()
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		err = fmt.Errorf("could not open the Stdout pipe for %s: %w", extensionName, err)
-		logs.Error(err)
+	admit := func(browserArgs util.BrowserArguments) error {
+		// the server might still run until it notices that the policy became invalid
+		err := settings.SystemPolicyError()
+		if err != nil {
+			return err
+		}
+		executable, err = serv.verifyExecutable()
+		if err != nil {
+			return err
+		}
+		executableSHA256 = executableChecksum(executable)
+		err = serv.approveExtension(ctx, log)
+		if err != nil {
+			return err
+		}
+		// a warm host brings its host slot along, so it can be used when all slots are taken
+		host = serv.takeWarmHost(browserArgs)
+		if host != nil {
+			slotAcquired = true
+			executableSHA256 = host.executableSHA256
+			return nil
+		}
+		err = serv.acquireHostSlot(appSettings.MaxProcesses)
+		slotAcquired = err == nil
 		return err
 	}
-	defer stdout.Close()
-
-	err = cmd.Start()
+	browserArgs, err := performHandshake(log, conn, hello, serv.ConfigFile.Content.Name, extensionName, verifyPeer, admit)
+	defer func() {
+		// only still open if no host was started with it
+		if executable != nil {
+			executable.Close()
+		}
+	}()
+	if slotAcquired {
+		defer func() {
+			serv.releaseHostSlot()
+			serv.refillWarmPool()
+		}()
+	}
 	if err != nil {
-		err = fmt.Errorf("could not start the command for %s: %w", extensionName, err)
-		logs.Error(err)
+		if host != nil {
+			host.stop(hostStopGracePeriod)
+		}
+		err = fmt.Errorf("handshake for %s failed: %w", extensionName, err)
+		log.Error(err)
 		return err
 	}
+	if hello.Kind == protocol.PingKind {
+		return nil
+	}
 
-	exitChan := make(chan error, 2)
-
-	go customCopyGo(stdin, conn, &copyWait, exitChan, extensionName, true, listenIn)
-	go customCopyGo(conn, stdout, &copyWait, exitChan, extensionName, false, listenIn)
-
-	select {
-	case <-stop:
-	case err := <-exitChan:
+	// the manifest path inside the sandbox is replaced by the path of the original config file
+	hostArgs := browserArgs.HostArguments(configPath)
+	connectedSince := time.Now()
+	serv.auditLaunched(peerPid, executableSHA256)
+	if host != nil {
+		log.Debug("using prewarmed host for", extensionName)
+	} else {
+		var cmd *exec.Cmd
+		cmd, err = serv.newHostCommand(appSettings, hostArgs)
 		if err == nil {
-			break
+			log.Debug("starting", cmd.Path, "with arguments", cmd.Args[1:], "in", cmd.Dir)
+			host, err = startHost(cmd, newHostLimits(appSettings), executable)
+			executable = nil
 		}
-		if errors.Is(err, io.EOF) {
-			logs.Debug("end of stream", extensionName)
-			break
+		if err != nil {
+			err = fmt.Errorf("could not start the command for %s: %w", extensionName, err)
+			log.Error(err)
+			serv.auditEnded(peerPid, connectedSince, nil, executableSHA256, err.Error())
+			return err
 		}
-		err = fmt.Errorf("failed to copy stream for %s: %w", extensionName, err)
-		logs.Error(err)
+	}
+	idleTimeout := appSettings.GetIdleTimeout()
+	var idleTimer *time.Timer
+	var idleTimerChan <-chan time.Time
+	// the activity is only tracked if it is needed, copying without it lets the runtime use splice(2)
+	var connectionActivity *activity
+	if idleTimeout > 0 {
+		connectionActivity = &activity{}
+		connectionActivity.touch()
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idleTimerChan = idleTimer.C
 	}
 
-	logs.Info("stopping connection for", extensionName)
+	exitChan := make(chan error, 2)
+	go customCopyGo(host.stdin, conn, &copyWait, exitChan, connectionActivity, extensionName, true, serv.ListenIn)
+	go customCopyGo(conn, host.stdout, &copyWait, exitChan, connectionActivity, extensionName, false, serv.ListenIn)
+
+	maxLifetime := appSettings.GetMaxLifetime()
+	var lifetimeTimerChan <-chan time.Time
+	if maxLifetime > 0 {
+		lifetimeTimer := time.NewTimer(maxLifetime)
+		defer lifetimeTimer.Stop()
+		lifetimeTimerChan = lifetimeTimer.C
+	}
+
+	reason := ""
+	for reason == "" {
+		select {
+		case <-stop:
+			reason = "the server is stopping"
+		case err := <-exitChan:
+			reason = "end of stream"
+			if err != nil && !errors.Is(err, io.EOF) {
+				err = fmt.Errorf("failed to copy stream for %s: %w", extensionName, err)
+				log.Error(err)
+				reason = "copy error"
+			}
+		case <-idleTimerChan:
+			idle := connectionActivity.idleFor()
+			if idle < idleTimeout {
+				idleTimer.Reset(idleTimeout - idle)
+				continue
+			}
+			reason = fmt.Sprint("no messages for ", idleTimeout)
+			log.Warn("closing idle connection for", extensionName, "after", idleTimeout, "without messages")
+		case <-lifetimeTimerChan:
+			reason = fmt.Sprint("maximum lifetime of ", maxLifetime, " reached")
+			log.Warn("closing connection for", extensionName, "because it reached the maximum lifetime of", maxLifetime)
+		}
+	}
+
+	host.stop(hostStopGracePeriod)
+
+	log.Info("stopping connection for", extensionName, "reason:", reason, "host:", host.exitDescription(), "duration:", time.Since(connectedSince).Round(time.Millisecond))
+	serv.auditEnded(peerPid, connectedSince, host, executableSHA256, reason)
 
 	return nil
 }
 
-func customCopyGo(dst io.Writer, src io.Reader, wg *sync.WaitGroup, exitChan chan error, extensionName string, isreceiver bool, enableSniffer bool) {
+// activity remembers when data was last copied in either direction.
+type activity struct {
+	lastUnixNano atomic.Int64
+}
+
+func (a *activity) touch() {
+	a.lastUnixNano.Store(time.Now().UnixNano())
+}
+
+func (a *activity) idleFor() time.Duration {
+	return time.Since(time.Unix(0, a.lastUnixNano.Load()))
+}
+
+type activityWriter struct {
+	writer   io.Writer
+	activity *activity
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.activity.touch()
+	return n, err
+}
+
+// customCopyGo copies plainly unless the sniffer or copyActivity need to see the data.
+// copyActivity can be nil.
+func customCopyGo(dst io.Writer, src io.Reader, wg *sync.WaitGroup, exitChan chan error, copyActivity *activity, extensionName string, isreceiver bool, enableSniffer bool) {
 	wg.Add(1)
 	defer wg.Done()
-	var err error
 
 	if enableSniffer {
 		dst = io.MultiWriter(dst, &sniffer{extensionName: extensionName, isReceiver: isreceiver})
 	}
-	_, err = io.Copy(dst, src)
+	if copyActivity != nil {
+		dst = &activityWriter{writer: dst, activity: copyActivity}
+	}
+	_, err := io.Copy(dst, src)
 	exitChan <- err
 }
 
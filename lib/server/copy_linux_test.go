@@ -0,0 +1,120 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// BenchmarkCopy moves data between a unix socket and a pipe like a connection to a host does,
+// once plainly, which lets the runtime use splice(2), and once through a writer tracking the activity.
+func BenchmarkCopy(b *testing.B) {
+	copiers := []struct {
+		name string
+		copy func(dst io.Writer, src io.Reader) error
+	}{
+		{"io.Copy", func(dst io.Writer, src io.Reader) error {
+			_, err := io.Copy(dst, src)
+			return err
+		}},
+		{"activity", func(dst io.Writer, src io.Reader) error {
+			_, err := io.Copy(&activityWriter{writer: dst, activity: &activity{}}, src)
+			return err
+		}},
+	}
+
+	for _, copier := range copiers {
+		b.Run("to host/"+copier.name, func(b *testing.B) {
+			benchmarkCopy(b, true, copier.copy)
+		})
+		b.Run("from host/"+copier.name, func(b *testing.B) {
+			benchmarkCopy(b, false, copier.copy)
+		})
+	}
+}
+
+const benchmarkChunkSize = 64 * 1024
+
+func benchmarkCopy(b *testing.B, toHost bool, copyData func(dst io.Writer, src io.Reader) error) {
+	connection, client := newSocketPair(b)
+	defer connection.Close()
+	defer client.Close()
+
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pipeReader.Close()
+	defer pipeWriter.Close()
+
+	// data flows from feed through src and dst into drain
+	var feed, dst io.WriteCloser
+	var src, drain io.Reader
+	if toHost {
+		feed, src, dst, drain = client, connection, pipeWriter, pipeReader
+	} else {
+		feed, src, dst, drain = pipeWriter, pipeReader, connection, client
+	}
+
+	drained := make(chan int64)
+	go func() {
+		n, _ := io.Copy(io.Discard, drain)
+		drained <- n
+	}()
+
+	chunk := make([]byte, benchmarkChunkSize)
+	b.SetBytes(benchmarkChunkSize)
+	b.ResetTimer()
+
+	go func() {
+		for range b.N {
+			_, err := feed.Write(chunk)
+			if err != nil {
+				break
+			}
+		}
+		closeWrite(feed)
+	}()
+
+	err = copyData(dst, src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	closeWrite(dst)
+
+	n := <-drained
+	b.StopTimer()
+	if n != int64(b.N)*benchmarkChunkSize {
+		b.Fatalf("copied %d bytes instead of %d", n, int64(b.N)*benchmarkChunkSize)
+	}
+}
+
+// closeWrite ends the stream without closing the socket for reading.
+func closeWrite(writer io.WriteCloser) {
+	conn, ok := writer.(*net.UnixConn)
+	if ok {
+		conn.CloseWrite()
+		return
+	}
+	writer.Close()
+}
+
+func newSocketPair(b *testing.B) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for index, fd := range fds {
+		file := os.NewFile(uintptr(fd), "socket")
+		conn, err := net.FileConn(file)
+		file.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[index] = conn.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
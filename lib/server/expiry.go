@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// expiryTimer fires when the next app that was enabled for a limited time expires.
+var expiryTimer struct {
+	sync.Mutex
+	timer *time.Timer
+}
+
+// disableExpiredApps disables apps whose time ran out and returns the ones that are still enabled.
+// The timer is set for the next app to expire.
+func disableExpiredApps(browser util.Browser, enabledConfigs []config.NativeConfigFile) []config.NativeConfigFile {
+	stillEnabled := []config.NativeConfigFile{}
+	var nextExpiry time.Time
+
+	for _, configFile := range enabledConfigs {
+		enabledUntil := configFile.EnabledUntil()
+		if enabledUntil.IsZero() {
+			stillEnabled = append(stillEnabled, configFile)
+			continue
+		}
+		if !enabledUntil.After(time.Now()) {
+			disableExpiredApp(configFile)
+			continue
+		}
+		stillEnabled = append(stillEnabled, configFile)
+		if nextExpiry.IsZero() || enabledUntil.Before(nextExpiry) {
+			nextExpiry = enabledUntil
+		}
+	}
+
+	expiryTimer.Lock()
+	defer expiryTimer.Unlock()
+	if expiryTimer.timer != nil {
+		expiryTimer.timer.Stop()
+		expiryTimer.timer = nil
+	}
+	if !nextExpiry.IsZero() {
+		log := logs.With("browser", string(browser))
+		log.Debug("next app expires at", nextExpiry.Format(time.RFC3339))
+		expiryTimer.timer = time.AfterFunc(time.Until(nextExpiry), func() {
+			enabledConfigs, err := config.CollectEnabledConfigFiles(browser)
+			if err != nil {
+				log.Error(fmt.Errorf("can't collect config files to disable expired apps: %w", err))
+				return
+			}
+			// disabling changes the settings, which stops the servers of the app
+			disableExpiredApps(browser, enabledConfigs)
+		})
+	}
+
+	return stillEnabled
+}
+
+func disableExpiredApp(configFile config.NativeConfigFile) {
+	browser := configFile.GetBrowser()
+	log := logs.With("browser", string(browser), "app", configFile.Name())
+	log.Info("disabling", configFile.Name(), "because the time it was enabled for is over")
+	err := configFile.Disable()
+	if err != nil {
+		log.Error(fmt.Errorf("could not disable expired app %s: %w", configFile.Name(), err))
+		return
+	}
+	notifyUser("App disabled", fmt.Sprintf("%s was disabled in %s because the time it was enabled for is over.", configFile.Content.Name, browser.GetName()))
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/protocol"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+var ErrProtocolMismatch = errors.New("client and server protocol versions don't match")
+
+// readClientHello reads the hello the client sends right after connecting.
+func readClientHello(conn net.Conn) (protocol.ClientHello, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	hello := protocol.ClientHello{}
+	err := protocol.ReadFrame(conn, &hello)
+	if err != nil {
+		err = fmt.Errorf("could not read hello from client: %w", err)
+	}
+	return hello, err
+}
+
+// performHandshake answers the hello of the client.
+// After a successful handshake only native messaging data is sent over the connection,
+// except for pings which are already answered.
+// verifyPeer runs before anything is answered, also for pings, so rejected clients can't find out which hosts exist.
+// admit gets the parsed arguments of a valid client and can refuse it, the error is sent to the client as the reason.
+// It can take as long as it needs, the client waits for the answer.
+func performHandshake(log *logs.Logger, conn net.Conn, hello *protocol.ClientHello, hostName string, extensionName string, verifyPeer func() error, admit func(util.BrowserArguments) error) (util.BrowserArguments, error) {
+	conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+
+	reject := func(err error) {
+		rejectErr := rejectHandshake(conn, err.Error())
+		if rejectErr != nil {
+			log.Warn("could not tell client about the failed handshake", rejectErr)
+		}
+	}
+
+	if hello.Kind == protocol.PingKind {
+		err := verifyPeer()
+		if err != nil {
+			reject(err)
+			return util.BrowserArguments{}, err
+		}
+		err = answerPing(log, conn, hello, hostName, extensionName)
+		return util.BrowserArguments{}, err
+	}
+
+	browserArgs, err := validateClientHello(hello, hostName, extensionName)
+	if err == nil {
+		err = verifyPeer()
+	}
+	if err == nil {
+		err = admit(browserArgs)
+		// admit can wait for the user to answer a prompt
+		conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	}
+	if err != nil {
+		reject(err)
+		return browserArgs, err
+	}
+
+	log.Info("client connected for", extensionName, "browser:", hello.Browser, "client version:", hello.ClientVersion, "pid:", hello.PID)
+	log.Debug("client manifest path:", hello.ManifestPath, "arguments:", hello.Args)
+	if len(browserArgs.DroppedArgs) > 0 {
+		log.Warn("not passing the arguments", browserArgs.DroppedArgs, "of the browser to the host of", extensionName, "because they are not allowed")
+	}
+
+	err = protocol.WriteFrame(conn, protocol.ServerHello{ProtocolVersion: protocol.Version, ServerVersion: util.GetVersion()})
+	if err != nil {
+		err = fmt.Errorf("could not answer hello of client: %w", err)
+		return browserArgs, err
+	}
+
+	return browserArgs, nil
+}
+
+func answerPing(log *logs.Logger, conn net.Conn, hello *protocol.ClientHello, hostName string, extensionName string) error {
+	err := validateProtocolVersion(hello)
+	if err != nil {
+		rejectErr := rejectHandshake(conn, err.Error())
+		if rejectErr != nil {
+			log.Warn("could not tell client about the failed handshake", rejectErr)
+		}
+		return err
+	}
+
+	log.Debug("answering ping for", extensionName, "client version:", hello.ClientVersion, "pid:", hello.PID)
+
+	return protocol.WriteFrame(conn, protocol.ServerHello{
+		ProtocolVersion: protocol.Version,
+		ServerVersion:   util.GetVersion(),
+		HostName:        hostName,
+		Extension:       extensionName,
+	})
+}
+
+func validateProtocolVersion(hello *protocol.ClientHello) error {
+	if hello.ProtocolVersion == protocol.Version {
+		return nil
+	}
+	var hint string
+	if hello.ProtocolVersion < protocol.Version {
+		hint = "the client is outdated, restart the browser so it picks up the client of this server"
+	} else {
+		hint = "the server is outdated, restart browser-glue after updating it"
+	}
+	return fmt.Errorf("%w: client %s speaks version %d, server %s speaks version %d, %s",
+		ErrProtocolMismatch, hello.ClientVersion, hello.ProtocolVersion, util.GetVersion(), protocol.Version, hint)
+}
+
+func validateClientHello(hello *protocol.ClientHello, hostName string, extensionName string) (util.BrowserArguments, error) {
+	err := validateProtocolVersion(hello)
+	if err != nil {
+		return util.BrowserArguments{}, err
+	}
+
+	browserArgs, err := util.ParseBrowserArguments(hello.Args)
+	if err != nil {
+		return browserArgs, err
+	}
+
+	if string(browserArgs.Flavour) != hello.Browser || browserArgs.Extension != hello.Extension || browserArgs.ManifestPath != hello.ManifestPath {
+		return browserArgs, errors.New("hello of client does not match its arguments")
+	}
+
+	if util.NormalizeExtensionName(browserArgs.Extension) != util.NormalizeExtensionName(extensionName) {
+		return browserArgs, fmt.Errorf("client was called for a different extension %s", browserArgs.Extension)
+	}
+
+	if hello.HostName != hostName {
+		return browserArgs, fmt.Errorf("client was called for a different host %s", hello.HostName)
+	}
+
+	return browserArgs, nil
+}
+
+func rejectHandshake(conn net.Conn, reason string) error {
+	return protocol.WriteFrame(conn, protocol.ServerHello{ProtocolVersion: protocol.Version, ServerVersion: util.GetVersion(), Error: reason})
+}
+
+const handshakeTimeout = 5 * time.Second
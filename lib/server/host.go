@@ -0,0 +1,292 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/settings"
+)
+
+// hostProcess is a running native host.
+// The pipes are created by us instead of exec, so they stay readable after the host exited.
+type hostProcess struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	limits hostLimits
+	// executableSHA256 is the checksum the executable was verified with when the host started
+	executableSHA256 string
+
+	exited  chan struct{}
+	waitErr error
+}
+
+// newHostCommand creates the command for the host of the app with the overrides and limits from its settings.
+func (serv *Server) newHostCommand(appSettings settings.AppSettings, args []string) (*exec.Cmd, error) {
+	commandPath := serv.ConfigFile.Content.Executable
+	commandLine, err := serv.hostCommandLine(appSettings, args, commandPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(commandLine[0], commandLine[1:]...)
+
+	cmd.Dir = filepath.Dir(commandPath)
+	if appSettings.WorkingDir != "" {
+		cmd.Dir = appSettings.WorkingDir
+	} else if !isDir(cmd.Dir) {
+		// the executable might only exist inside a container or flatpak, the host starts in our working directory then
+		cmd.Dir = ""
+	}
+
+	if appSettings.ClearEnv {
+		cmd.Env = append([]string{}, appSettings.Env...)
+	} else if len(appSettings.Env) > 0 {
+		cmd.Env = append(os.Environ(), appSettings.Env...)
+	}
+
+	// own process group, so processes started by the host can be stopped with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, nil
+}
+
+// startHost checks right before starting the host that its executable is still the verified one, the executable is closed afterwards.
+// The host is started from the path and not from the open file, so scripts still find the files next to them.
+// executable is nil if it can't be verified.
+func startHost(cmd *exec.Cmd, limits hostLimits, executable *config.VerifiedExecutable) (*hostProcess, error) {
+	if executable != nil {
+		defer executable.Close()
+	}
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not open the Stdin pipe: %w", err)
+	}
+	defer stdinReader.Close()
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinWriter.Close()
+		return nil, fmt.Errorf("could not open the Stdout pipe: %w", err)
+	}
+	defer stdoutWriter.Close()
+
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+
+	if executable != nil {
+		err = executable.Unchanged()
+	}
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, err
+	}
+
+	host := &hostProcess{
+		cmd:              cmd,
+		stdin:            stdinWriter,
+		stdout:           stdoutReader,
+		limits:           limits,
+		executableSHA256: executableChecksum(executable),
+		exited:           make(chan struct{}),
+	}
+	go func() {
+		host.waitErr = cmd.Wait()
+		close(host.exited)
+	}()
+
+	return host, nil
+}
+
+func (host *hostProcess) hasExited() bool {
+	select {
+	case <-host.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// hostCommandLine adds the extra arguments from the settings to the arguments of the browser,
+// wraps the executable if the host is a flatpak app or the app has a wrapper
+// and puts the commands applying the resource limits in front.
+func (serv *Server) hostCommandLine(appSettings settings.AppSettings, args []string, executable string) ([]string, error) {
+	commandLine, err := newHostLimits(appSettings).commandPrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(slices.Clone(args), appSettings.ExtraArgs...)
+	if serv.ConfigFile.FlatpakHostId != "" {
+		commandLine = append(commandLine, "flatpak", "run", "--command="+executable, serv.ConfigFile.FlatpakHostId)
+		return append(commandLine, args...), nil
+	}
+	return append(commandLine, appSettings.WrapCommand(executable, args)...), nil
+}
+
+// CheckHostExecutable reports if the host of an app can't be started.
+// With a wrapper or flatpak the executable might only exist inside a sandbox, so only the command starting it is checked.
+func CheckHostExecutable(configFile config.NativeConfigFile, appSettings settings.AppSettings) error {
+	limitsPrefix, err := newHostLimits(appSettings).commandPrefix()
+	if err != nil {
+		return err
+	}
+	for _, limitsCommand := range []string{"nice", "prlimit"} {
+		if !slices.Contains(limitsPrefix, limitsCommand) {
+			continue
+		}
+		_, err := exec.LookPath(limitsCommand)
+		if err != nil {
+			return fmt.Errorf("%s is needed for the resource limits but can't be started: %w", limitsCommand, err)
+		}
+	}
+
+	executable := configFile.Content.Executable
+	if configFile.FlatpakHostId != "" {
+		_, err := exec.LookPath("flatpak")
+		if err != nil {
+			return fmt.Errorf("host is the flatpak app %s but flatpak can't be started: %w", configFile.FlatpakHostId, err)
+		}
+		return nil
+	}
+
+	if appSettings.Wrapper != "" {
+		wrapperCommand := appSettings.WrapCommand(executable, nil)[0]
+		_, err := exec.LookPath(wrapperCommand)
+		if err != nil {
+			return fmt.Errorf("wrapper %s can't be started: %w", wrapperCommand, err)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return fmt.Errorf("executable can't be found: %w", err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", executable)
+	}
+	return nil
+}
+
+// verifyExecutable refuses executables that changed since the user approved them or were never approved.
+// The returned executable is nil if it can't be verified because it is not on this system.
+func (serv *Server) verifyExecutable() (*config.VerifiedExecutable, error) {
+	executable, status, err := serv.ConfigFile.OpenExecutable()
+	switch status {
+	case config.ExecutableChanged:
+		return nil, fmt.Errorf("%w, approve it with \"browser-glue apps approve %s\"", err, serv.ConfigFile.Name())
+	case config.ExecutableNotPinned:
+		serv.notifyNotPinned()
+		return nil, fmt.Errorf("%w, approve it with \"browser-glue apps approve %s\"", ErrExecutableNotApproved, serv.ConfigFile.Name())
+	}
+	return executable, err
+}
+
+// executableChecksum is the checksum recorded for a host started from executable.
+func executableChecksum(executable *config.VerifiedExecutable) string {
+	if executable == nil {
+		return string(config.ExecutableUnverifiable)
+	}
+	return executable.SHA256
+}
+
+var ErrExecutableNotApproved = errors.New("host executable was never approved")
+
+type notifiedAppsSafe struct {
+	sync.Mutex
+	apps map[string]bool
+}
+
+// notifiedNotPinned remembers the apps the user was told about, so not every connection shows a notification.
+var notifiedNotPinned = notifiedAppsSafe{apps: map[string]bool{}}
+
+func (serv *Server) notifyNotPinned() {
+	browser := serv.ConfigFile.GetBrowser()
+	key := string(browser) + "/" + serv.ConfigFile.Name()
+
+	notifiedNotPinned.Lock()
+	notified := notifiedNotPinned.apps[key]
+	notifiedNotPinned.apps[key] = true
+	notifiedNotPinned.Unlock()
+
+	serv.logger().Warn("refusing to start the host of", serv.ConfigFile.Name(), "because its executable", serv.ConfigFile.Content.Executable, "was never approved")
+	if !notified {
+		notifyUser("Host not approved", fmt.Sprintf("The host of %s in %s is not started until you approve its executable %s with \"browser-glue apps approve %s\".",
+			serv.ConfigFile.Content.Name, browser.GetName(), serv.ConfigFile.Content.Executable, serv.ConfigFile.Name()))
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// exitDescription says how the host exited, including limits it violated.
+func (host *hostProcess) exitDescription() string {
+	if !host.hasExited() {
+		return "still running"
+	}
+	state := host.cmd.ProcessState
+	if state == nil {
+		return fmt.Sprint("could not wait for the host: ", host.waitErr)
+	}
+
+	status, _ := state.Sys().(syscall.WaitStatus)
+	violation := host.limits.describeViolation(&status, state.UserTime()+state.SystemTime())
+	if violation != "" {
+		return violation
+	}
+	return state.String()
+}
+
+// stop asks the host to exit by closing its input and forces it if it doesn't react in time.
+func (host *hostProcess) stop(gracePeriod time.Duration) error {
+	host.stdin.Close()
+	host.stdout.Close()
+
+	select {
+	case <-host.exited:
+		return host.waitErr
+	case <-time.After(gracePeriod):
+	}
+
+	err := host.signalGroup(syscall.SIGTERM)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-host.exited:
+		return host.waitErr
+	case <-time.After(gracePeriod):
+	}
+
+	err = host.signalGroup(syscall.SIGKILL)
+	if err != nil {
+		return err
+	}
+	<-host.exited
+	return host.waitErr
+}
+
+func (host *hostProcess) signalGroup(signal syscall.Signal) error {
+	err := syscall.Kill(-host.cmd.Process.Pid, signal)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+const hostStopGracePeriod = 2 * time.Second
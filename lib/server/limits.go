@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/settings"
+)
+
+// hostLimits are applied to every host process of an app, zero values are not applied.
+type hostLimits struct {
+	maxOpenFiles uint64
+	maxMemory    uint64
+	cpuTime      time.Duration
+	niceness     int
+}
+
+func newHostLimits(appSettings settings.AppSettings) hostLimits {
+	return hostLimits{
+		maxOpenFiles: uint64(appSettings.MaxOpenFiles),
+		maxMemory:    uint64(appSettings.MaxMemoryMiB) * 1024 * 1024,
+		cpuTime:      appSettings.GetCPUTime(),
+		niceness:     appSettings.Niceness,
+	}
+}
+
+// describeViolation explains why a host was killed if one of the limits caused it.
+func (limits hostLimits) describeViolation(state *syscall.WaitStatus, usedCPUTime time.Duration) string {
+	if state == nil || !state.Signaled() {
+		return ""
+	}
+	signal := state.Signal()
+
+	if limits.cpuTime > 0 && (signal == syscall.SIGXCPU || (signal == syscall.SIGKILL && usedCPUTime >= limits.cpuTime)) {
+		return fmt.Sprint("exceeded the CPU time limit of ", limits.cpuTime)
+	}
+	if limits.maxMemory > 0 && (signal == syscall.SIGSEGV || signal == syscall.SIGABRT || signal == syscall.SIGBUS) {
+		return fmt.Sprintf("crashed with %s, possibly because of the memory limit of %d MiB", signal, limits.maxMemory/1024/1024)
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// commandPrefix starts the host through nice and prlimit, so the limits are set before anything of the host runs.
+// Both exec the next command, so the host keeps the pid that was started,
+// and wrappers like flatpak run pass the limits on to the host they start.
+// Hosts started by a daemon, like podman exec does, don't get them.
+func (limits hostLimits) commandPrefix() ([]string, error) {
+	prefix := []string{}
+	if limits.niceness != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(limits.niceness))
+	}
+
+	resourceLimits := []string{}
+	if limits.maxOpenFiles > 0 {
+		resourceLimits = append(resourceLimits, fmt.Sprint("--nofile=", limits.maxOpenFiles))
+	}
+	if limits.maxMemory > 0 {
+		resourceLimits = append(resourceLimits, fmt.Sprint("--as=", limits.maxMemory))
+	}
+	if limits.cpuTime > 0 {
+		// the host gets SIGXCPU first and is killed once the hard limit is reached
+		seconds := uint64(max(limits.cpuTime.Seconds(), 1))
+		hardSeconds := seconds + uint64(hostStopGracePeriod.Seconds())
+		resourceLimits = append(resourceLimits, fmt.Sprintf("--cpu=%d:%d", seconds, hardSeconds))
+	}
+	if len(resourceLimits) > 0 {
+		prefix = append(prefix, "prlimit")
+		prefix = append(prefix, resourceLimits...)
+		prefix = append(prefix, "--")
+	}
+
+	return prefix, nil
+}
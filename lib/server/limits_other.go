@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+func (limits hostLimits) commandPrefix() ([]string, error) {
+	if limits == (hostLimits{}) {
+		return nil, nil
+	}
+	return nil, errors.New("resource limits are only supported on linux")
+}
@@ -11,7 +11,14 @@ import (
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
-func RunEnabledServersBackground(browser util.Browser, listenIn bool, allServersExited chan<- struct{}) {
+// RunEnabledServersBackground starts servers for all enabled apps.
+// With multiplex all servers of a browser share a single socket.
+func RunEnabledServersBackground(browser util.Browser, listenIn bool, multiplex bool, allServersExited chan<- struct{}) {
+	log := logs.With("browser", string(browser))
+	if listenIn && settings.ListenInDisabledByPolicy() {
+		log.Warn("listening in is disabled by the system policy")
+		listenIn = false
+	}
 	if allServersExited != nil {
 		allExitedSignal.subscribe(allServersExited)
 	}
@@ -23,10 +30,10 @@ func RunEnabledServersBackground(browser util.Browser, listenIn bool, allServers
 		for {
 			<-changes
 
-			err := refreshEnabledServers(browser, listenIn)
+			err := refreshEnabledServers(browser, listenIn, multiplex)
 			if err != nil {
 				err = fmt.Errorf("failed reloading servers: %w", err)
-				logs.Error(err)
+				log.Error(err)
 
 				StopServers()
 			}
@@ -47,15 +54,21 @@ func StopServers() {
 	logs.Debug("all servers exited")
 }
 
-func refreshEnabledServers(browser util.Browser, listenIn bool) error {
+func refreshEnabledServers(browser util.Browser, listenIn bool, multiplex bool) error {
+	log := logs.With("browser", string(browser))
 	enabledNativeConfigs, err := config.CollectEnabledConfigFiles(browser)
 	if err != nil {
 		err = fmt.Errorf("can't collect config files: %w", err)
-		logs.Error(err)
+		log.Error(err)
 		return err
 	}
+	enabledNativeConfigs = disableExpiredApps(browser, enabledNativeConfigs)
+	policyErr := settings.SystemPolicyError()
+	if policyErr != nil {
+		log.Error(fmt.Errorf("stopping all servers: %w", policyErr))
+	}
 	if len(enabledNativeConfigs) == 0 {
-		logs.Warn("No config files are currently enabled.")
+		log.Warn("No config files are currently enabled.")
 		StopServers()
 		return nil
 	}
@@ -64,7 +77,7 @@ func refreshEnabledServers(browser util.Browser, listenIn bool) error {
 	defer runningServers.Unlock()
 
 	for _, runningServer := range runningServers.servers {
-		if !runningServer.ConfigFile.IsEnabled() {
+		if !runningServer.ConfigFile.IsEnabled() || !slices.Contains(runningServer.ConfigFile.EnabledExtensions(), runningServer.ExtensionName) {
 			runningServer.StopBackground()
 		}
 	}
@@ -77,13 +90,13 @@ func refreshEnabledServers(browser util.Browser, listenIn bool) error {
 			}
 		}
 
-		for _, extensionName := range enabledConfig.Content.GetExtensions() {
+		for _, extensionName := range enabledConfig.EnabledExtensions() {
 
 			if slices.Contains(runningExtensions, extensionName) {
 				continue
 			}
 
-			server := Server{ConfigFile: enabledConfig, ExtensionName: extensionName, ListenIn: listenIn}
+			server := Server{ConfigFile: enabledConfig, ExtensionName: extensionName, ListenIn: listenIn, Multiplex: multiplex}
 
 			server.RunBackground()
 		}
@@ -136,7 +149,7 @@ func serverManagerRoutine() {
 
 				err := server.run()
 				if err != nil {
-					logs.Error(err)
+					server.logger().Error(err)
 				}
 
 				runningServers.Lock()
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/protocol"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// routedConnection is a connection from the multiplexed socket whose hello was already read.
+type routedConnection struct {
+	conn  net.Conn
+	hello protocol.ClientHello
+}
+
+// servers can't take routed connections while they are shutting down, so they are buffered
+const routedConnectionsBuffer = 16
+
+type routeKey struct {
+	hostName      string
+	extensionName string
+}
+
+// multiplexer listens on a single socket for all servers of a browser
+// and routes each connection to the server of the host and extension the client asks for.
+type multiplexer struct {
+	browser  util.Browser
+	listener net.Listener
+	servers  map[routeKey]*Server
+}
+
+type multiplexersSafe struct {
+	sync.Mutex
+	multiplexers map[util.Browser]*multiplexer
+}
+
+var multiplexers = multiplexersSafe{multiplexers: map[util.Browser]*multiplexer{}}
+
+func registerMultiplexed(serv *Server) error {
+	multiplexers.Lock()
+	defer multiplexers.Unlock()
+
+	browser := serv.ConfigFile.GetBrowser()
+	mux, ok := multiplexers.multiplexers[browser]
+	if !ok {
+		var err error
+		mux, err = startMultiplexer(browser)
+		if err != nil {
+			return err
+		}
+		multiplexers.multiplexers[browser] = mux
+	}
+
+	key := routeKey{hostName: serv.ConfigFile.Content.Name, extensionName: util.NormalizeExtensionName(serv.ExtensionName)}
+	mux.servers[key] = serv
+	return nil
+}
+
+func unregisterMultiplexed(serv *Server) {
+	multiplexers.Lock()
+	defer multiplexers.Unlock()
+
+	browser := serv.ConfigFile.GetBrowser()
+	mux, ok := multiplexers.multiplexers[browser]
+	if !ok {
+		return
+	}
+
+	key := routeKey{hostName: serv.ConfigFile.Content.Name, extensionName: util.NormalizeExtensionName(serv.ExtensionName)}
+	if mux.servers[key] == serv {
+		delete(mux.servers, key)
+	}
+
+	if len(mux.servers) == 0 {
+		mux.logger().Info("closing multiplexed socket for", browser.GetName())
+		mux.listener.Close()
+		delete(multiplexers.multiplexers, browser)
+	}
+}
+
+func startMultiplexer(browser util.Browser) (*multiplexer, error) {
+	socketPath := filepath.Join(browser.GetSocketFolder(), protocol.MultiplexSocketName)
+	log := logs.With("browser", string(browser))
+
+	err := util.MkdirSecure(filepath.Dir(socketPath), 0o700)
+	if err != nil {
+		err = fmt.Errorf("can't create socket folder: %w", err)
+		log.Error(err)
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		err = fmt.Errorf("can't listen on socket %s: %w", socketPath, err)
+		log.Error(err)
+		return nil, err
+	}
+
+	log.Info("multiplexed socket for", browser.GetName(), "listening on", socketPath)
+
+	mux := &multiplexer{browser: browser, listener: listener, servers: map[routeKey]*Server{}}
+	go mux.acceptLoop()
+	return mux, nil
+}
+
+// logger adds the browser of the multiplexer to messages.
+func (mux *multiplexer) logger() *logs.Logger {
+	return logs.With("browser", string(mux.browser))
+}
+
+func (mux *multiplexer) acceptLoop() {
+	retries := 0
+	for {
+		conn, err := mux.listener.Accept()
+		if err == nil {
+			retries = 0
+			go mux.route(conn)
+			continue
+		}
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if retries >= 5 {
+			mux.logger().Error(fmt.Errorf("failed to accept connections on the multiplexed socket of %s: %w", mux.browser.GetName(), err))
+			return
+		}
+		mux.logger().Warn("retrying connection on the multiplexed socket of", mux.browser.GetName(), err)
+		retries++
+	}
+}
+
+func (mux *multiplexer) route(conn net.Conn) {
+	hello, err := readClientHello(conn)
+	if err != nil {
+		mux.logger().Error(fmt.Errorf("handshake on the multiplexed socket of %s failed: %w", mux.browser.GetName(), err))
+		conn.Close()
+		return
+	}
+
+	// pings without a host only check that the socket works
+	if hello.Kind == protocol.PingKind && hello.HostName == "" {
+		err = answerPing(mux.logger(), conn, &hello, "", "")
+		if err != nil {
+			mux.logger().Warn("could not answer ping on the multiplexed socket", err)
+		}
+		conn.Close()
+		return
+	}
+
+	found, routed := mux.routeToServer(conn, hello)
+	if routed {
+		return
+	}
+
+	reason := fmt.Sprintf("no server is running for host %s and extension %s", hello.HostName, hello.Extension)
+	if found {
+		reason = fmt.Sprintf("the server for host %s and extension %s is busy", hello.HostName, hello.Extension)
+	}
+	mux.logger().Warn("rejecting connection on the multiplexed socket of", mux.browser.GetName()+":", reason)
+
+	err = rejectHandshake(conn, reason)
+	if err != nil {
+		mux.logger().Warn("could not tell client about the failed handshake", err)
+	}
+	conn.Close()
+}
+
+// routeToServer hands the connection over while holding the lock,
+// so servers don't receive connections after they unregistered.
+func (mux *multiplexer) routeToServer(conn net.Conn, hello protocol.ClientHello) (found bool, routed bool) {
+	key := routeKey{hostName: hello.HostName, extensionName: util.NormalizeExtensionName(hello.Extension)}
+
+	multiplexers.Lock()
+	defer multiplexers.Unlock()
+
+	serv, found := mux.servers[key]
+	if !found {
+		return false, false
+	}
+
+	select {
+	case serv.routed <- routedConnection{conn: conn, hello: hello}:
+		return true, true
+	default:
+		return true, false
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"os/exec"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+)
+
+// notifyUser shows a desktop notification if notify-send is installed.
+func notifyUser(summary string, body string) {
+	cmd := exec.Command("notify-send", "--app-name=Browser Glue", summary, body)
+	err := cmd.Start()
+	if err != nil {
+		logs.Debug("could not show notification", err)
+		return
+	}
+	go cmd.Wait()
+}
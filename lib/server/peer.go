@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
+)
+
+var ErrPeerRejected = errors.New("connection is not from the browser")
+
+// peerCredentials are the credentials of the process on the other end of a socket, as seen by the kernel.
+type peerCredentials struct {
+	pid int
+	uid int
+}
+
+// verifyPeer checks that the client runs as our user and inside the flatpak of the browser.
+// Other users are always rejected, a client outside of the flatpak is only rejected in strict mode.
+// The pid of the client is 0 if its credentials can't be read.
+func (serv *Server) verifyPeer(log *logs.Logger, conn net.Conn, appSettings settings.AppSettings) (int, error) {
+	browser := serv.ConfigFile.GetBrowser()
+	strict := appSettings.PeerVerification == settings.PeerVerificationStrict
+
+	credentials, err := readPeerCredentials(conn)
+	if err != nil {
+		if strict {
+			return 0, fmt.Errorf("%w: could not read its credentials: %w", ErrPeerRejected, err)
+		}
+		log.Warn("could not read the credentials of the client for", serv.ExtensionName, err)
+		return 0, nil
+	}
+
+	if credentials.uid != os.Getuid() {
+		return credentials.pid, fmt.Errorf("%w: client pid %d runs as user %d", ErrPeerRejected, credentials.pid, credentials.uid)
+	}
+
+	flatpakId, fromCgroup, err := flatpakIdOfProcess(credentials.pid)
+	if err == nil && flatpakId == browser.GetFlatpakId() {
+		if fromCgroup {
+			log.Warn("could not look into the sandbox of client pid", credentials.pid, "for", serv.ExtensionName+", only its cgroup was checked, which is weaker")
+		}
+		return credentials.pid, nil
+	}
+
+	var reason string
+	if err != nil {
+		reason = fmt.Sprintf("can't find out if client pid %d runs in a flatpak: %s", credentials.pid, err)
+	} else if flatpakId == "" {
+		reason = fmt.Sprintf("client pid %d does not run in a flatpak", credentials.pid)
+	} else {
+		reason = fmt.Sprintf("client pid %d runs in the flatpak %s instead of %s", credentials.pid, flatpakId, browser.GetFlatpakId())
+	}
+
+	if strict {
+		return credentials.pid, fmt.Errorf("%w: %s", ErrPeerRejected, reason)
+	}
+	log.Warn("allowing connection for", serv.ExtensionName, "in permissive mode:", reason)
+	return credentials.pid, nil
+}
+
+// flatpakIdOfProcess returns an empty id if the process doesn't run in a flatpak.
+// flatpak puts .flatpak-info into the root of every sandbox, a process without it is not in one.
+// Only if we are not allowed to look into the root the cgroup of the process is used and fromCgroup is true.
+// That is a weaker check, any process can start itself in a scope with the name flatpak uses through systemd-run.
+func flatpakIdOfProcess(pid int) (flatpakId string, fromCgroup bool, err error) {
+	procPath := filepath.Join("/proc", strconv.Itoa(pid))
+
+	infoFile, infoErr := os.Open(filepath.Join(procPath, "root", ".flatpak-info"))
+	if infoErr == nil {
+		defer infoFile.Close()
+		flatpakId, err = readFlatpakInfoName(infoFile)
+		return flatpakId, false, err
+	}
+	if errors.Is(infoErr, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if !errors.Is(infoErr, os.ErrPermission) {
+		return "", false, infoErr
+	}
+
+	cgroup, err := os.ReadFile(filepath.Join(procPath, "cgroup"))
+	if err != nil {
+		return "", true, errors.Join(infoErr, err)
+	}
+	flatpakId, ok := flatpakIdFromCgroup(string(cgroup))
+	if !ok {
+		return "", true, infoErr
+	}
+	return flatpakId, true, nil
+}
+
+func readFlatpakInfoName(infoFile *os.File) (string, error) {
+	scanner := bufio.NewScanner(infoFile)
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if section == "[Application]" && found && strings.TrimSpace(key) == "name" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	if scanner.Err() != nil {
+		return "", scanner.Err()
+	}
+	return "", errors.New(".flatpak-info does not contain the name of the app")
+}
+
+// flatpakIdFromCgroup finds the scope systemd creates for flatpak apps, like app-flatpak-org.mozilla.firefox-1234.scope
+func flatpakIdFromCgroup(cgroup string) (string, bool) {
+	for _, line := range strings.Split(cgroup, "\n") {
+		for _, part := range strings.Split(line, "/") {
+			scope, found := strings.CutPrefix(part, "app-flatpak-")
+			if !found || !strings.HasSuffix(scope, ".scope") {
+				continue
+			}
+			scope = strings.TrimSuffix(scope, ".scope")
+			lastDash := strings.LastIndex(scope, "-")
+			if lastDash <= 0 {
+				continue
+			}
+			return scope[:lastDash], true
+		}
+	}
+	return "", false
+}
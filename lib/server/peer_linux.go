@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func readPeerCredentials(conn net.Conn) (peerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCredentials{}, errors.New("not a unix socket")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCredentials{}, err
+	}
+
+	var ucred *unix.Ucred
+	var ucredErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = ucredErr
+	}
+	if err != nil {
+		return peerCredentials{}, err
+	}
+	return peerCredentials{pid: int(ucred.Pid), uid: int(ucred.Uid)}, nil
+}
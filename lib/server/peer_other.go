@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func readPeerCredentials(conn net.Conn) (peerCredentials, error) {
+	return peerCredentials{}, errors.New("peer credentials are only supported on linux")
+}
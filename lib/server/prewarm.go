@@ -0,0 +1,183 @@
+package server
+
+import (
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/taukakao/browser-glue/lib/config"
+	"github.com/taukakao/browser-glue/lib/logs"
+)
+
+// warmPool keeps hosts running before a connection arrives,
+// because extensions using runtime.sendNativeMessage start a new host for every message.
+// Every warm host holds one of the host slots of the server, a connection taking it takes over its slot.
+type warmPool struct {
+	sync.Mutex
+	size        int
+	idleTimeout time.Duration
+	args        []string
+	newCommand  func() (*exec.Cmd, *config.VerifiedExecutable, error)
+	limits      hostLimits
+	acquireSlot func() error
+	releaseSlot func()
+	hosts       []*warmHost
+	closed      bool
+	stopReaper  chan struct{}
+	name        string
+	log         *logs.Logger
+}
+
+type warmHost struct {
+	host       *hostProcess
+	readySince time.Time
+}
+
+// args are the arguments of the browser all warm hosts are started with by newCommand,
+// it also returns the verified executable the command starts.
+func newWarmPool(name string, log *logs.Logger, size int, idleTimeout time.Duration, args []string, newCommand func() (*exec.Cmd, *config.VerifiedExecutable, error), limits hostLimits, acquireSlot func() error, releaseSlot func()) *warmPool {
+	pool := &warmPool{
+		size:        size,
+		idleTimeout: idleTimeout,
+		args:        args,
+		newCommand:  newCommand,
+		limits:      limits,
+		acquireSlot: acquireSlot,
+		releaseSlot: releaseSlot,
+		stopReaper:  make(chan struct{}),
+		name:        name,
+		log:         log,
+	}
+	go pool.refill()
+	go pool.reaper()
+	return pool
+}
+
+// take returns a warm host if the arguments are the ones the warm hosts were started with, otherwise nil.
+// The caller owns the host slot of the returned host, a replacement is started in the background.
+func (pool *warmPool) take(args []string) *hostProcess {
+	if !slices.Equal(pool.args, args) {
+		pool.log.Debug("prewarmed hosts for", pool.name, "were started with different arguments")
+		return nil
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+
+	for len(pool.hosts) > 0 {
+		warm := pool.hosts[0]
+		pool.hosts = pool.hosts[1:]
+
+		if warm.host.hasExited() {
+			pool.log.Warn("prewarmed host for", pool.name, "exited before it was used")
+			go pool.discard(warm)
+			continue
+		}
+
+		go pool.refill()
+		return warm.host
+	}
+
+	go pool.refill()
+	return nil
+}
+
+func (pool *warmPool) refill() {
+	for {
+		pool.Lock()
+		if pool.closed || len(pool.hosts) >= pool.size {
+			pool.Unlock()
+			return
+		}
+		pool.Unlock()
+
+		err := pool.acquireSlot()
+		if err != nil {
+			pool.log.Debug("not prewarming host for", pool.name, err)
+			return
+		}
+		cmd, executable, err := pool.newCommand()
+		if err != nil {
+			pool.releaseSlot()
+			pool.log.Warn("could not prewarm host for", pool.name, err)
+			return
+		}
+		host, err := startHost(cmd, pool.limits, executable)
+		if err != nil {
+			pool.releaseSlot()
+			pool.log.Warn("could not prewarm host for", pool.name, err)
+			return
+		}
+
+		pool.Lock()
+		if pool.closed || len(pool.hosts) >= pool.size {
+			pool.Unlock()
+			pool.discard(&warmHost{host: host})
+			return
+		}
+		pool.hosts = append(pool.hosts, &warmHost{host: host, readySince: time.Now()})
+		pool.Unlock()
+		pool.log.Debug("prewarmed a host for", pool.name)
+	}
+}
+
+// reaper stops warm hosts that were idle for too long, they are only replaced after the next connection.
+func (pool *warmPool) reaper() {
+	ticker := time.NewTicker(max(pool.idleTimeout/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.stopReaper:
+			return
+		case <-ticker.C:
+		}
+
+		pool.Lock()
+		idleHosts := []*warmHost{}
+		pool.hosts = slices.DeleteFunc(pool.hosts, func(warm *warmHost) bool {
+			idle := time.Since(warm.readySince) >= pool.idleTimeout || warm.host.hasExited()
+			if idle {
+				idleHosts = append(idleHosts, warm)
+			}
+			return idle
+		})
+		pool.Unlock()
+
+		for _, warm := range idleHosts {
+			pool.log.Debug("stopping idle prewarmed host for", pool.name)
+			go pool.discard(warm)
+		}
+	}
+}
+
+// close waits until the warm hosts are stopped, so their host slots are free for the hosts replacing them.
+func (pool *warmPool) close() {
+	pool.Lock()
+	if pool.closed {
+		pool.Unlock()
+		return
+	}
+	pool.closed = true
+	close(pool.stopReaper)
+	hosts := pool.hosts
+	pool.hosts = nil
+	pool.Unlock()
+
+	var discardWait sync.WaitGroup
+	for _, warm := range hosts {
+		discardWait.Add(1)
+		go func() {
+			defer discardWait.Done()
+			pool.discard(warm)
+		}()
+	}
+	discardWait.Wait()
+}
+
+// discard stops a warm host that won't be used and frees its host slot once it is gone.
+func (pool *warmPool) discard(warm *warmHost) {
+	warm.host.stop(hostStopGracePeriod)
+	pool.releaseSlot()
+}
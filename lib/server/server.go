@@ -1,27 +1,50 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
-	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"sync"
 
 	"github.com/taukakao/browser-glue/lib/config"
 	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/settings"
 	"github.com/taukakao/browser-glue/lib/util"
 )
 
 var ErrAlreadyRunning = errors.New("server already running")
 
+var errServerStopping = errors.New("the server is stopping")
+
 type Server struct {
 	ConfigFile    config.NativeConfigFile
 	ExtensionName string
 	ListenIn      bool
+	// Multiplex makes the server receive its connections from the shared socket of the browser instead of its own socket.
+	Multiplex bool
 
 	running bool
 	stop    chan struct{}
+	routed  chan routedConnection
+
+	// settingsMutex guards the warm pool and the settings it was started with, it is replaced when they change
+	settingsMutex  sync.Mutex
+	poolSettings   settings.AppSettings
+	settingsLoaded bool
+	warmPool       *warmPool
+
+	// hostsMutex guards runningHosts, which are limited by the MaxProcesses setting
+	hostsMutex   sync.Mutex
+	runningHosts int
+}
+
+// logger adds the browser, app and extension of the server to messages.
+func (serv *Server) logger() *logs.Logger {
+	return logs.With("browser", string(serv.ConfigFile.GetBrowser()), "app", serv.ConfigFile.Name(), "extension", serv.ExtensionName)
 }
 
 func (serv *Server) RunBackground() {
@@ -47,72 +70,239 @@ func (serv *Server) run() error {
 
 	serv.stop = make(chan struct{}, 1)
 
-	defer logs.Debug("server exited", serv.ExtensionName)
+	log := serv.logger()
+	defer log.Debug("server exited", serv.ExtensionName)
 
 	browser := serv.ConfigFile.GetBrowser()
-	writeClientExecutable(browser.GetClientPath())
+	hostName := serv.ConfigFile.Content.Name
+	writeClientExecutable(browser)
+	writeHostClientLink(browser, hostName)
+	removeLegacySockets(browser)
 
-	socketDir := browser.GetFlatpakRuntimeAppFolder()
-	socketFileName := util.GenerateSocketFileName(serv.ExtensionName)
+	serv.loadAppSettings(log)
+	defer serv.closeWarmPool()
 
-	socketPath := filepath.Join(socketDir, socketFileName)
+	connChan := make(chan net.Conn, 1)
+	errChan := make(chan error, 1)
+	var accept func()
 
-	os.MkdirAll(filepath.Dir(socketPath), 0o775)
-	listener, err := net.Listen("unix", socketPath)
+	if serv.Multiplex {
+		serv.routed = make(chan routedConnection, routedConnectionsBuffer)
+		err := registerMultiplexed(serv)
+		if err != nil {
+			return err
+		}
+		defer serv.closeRouted()
 
-	if err != nil {
-		err = fmt.Errorf("can't listen on socket %s: %w", socketPath, err)
-		logs.Error(err)
-		return err
-	}
-	defer listener.Close()
+		log.Info("Server for", serv.ExtensionName, "listening on the multiplexed socket of", browser.GetName())
+		accept = func() {}
+	} else {
+		socketDir := browser.GetSocketFolder()
+		socketFileName := util.GenerateSocketFileName(hostName, serv.ExtensionName)
 
-	logs.Info("Server for", serv.ExtensionName, "listening on", socketPath)
+		socketPath := filepath.Join(socketDir, socketFileName)
+
+		err := util.MkdirSecure(filepath.Dir(socketPath), 0o700)
+		if err != nil {
+			err = fmt.Errorf("can't create socket folder: %w", err)
+			log.Error(err)
+			return err
+		}
+		listener, err := net.Listen("unix", socketPath)
+
+		if err != nil {
+			err = fmt.Errorf("can't listen on socket %s: %w", socketPath, err)
+			log.Error(err)
+			return err
+		}
+		defer listener.Close()
+
+		log.Info("Server for", serv.ExtensionName, "listening on", socketPath)
+
+		accept = func() {
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				connChan <- conn
+			}()
+		}
+	}
 
 	retries := 0
 
 	stopConnectionSignal := make(chan bool)
 	var connectionWait sync.WaitGroup
+	// connections that are still in their handshake, like waiting for an approval prompt, don't listen to stopConnectionSignal
+	connectionsCtx, stopConnections := context.WithCancelCause(context.Background())
+	defer stopConnections(nil)
 
 	for {
-		connChan := make(chan net.Conn, 1)
-		errChan := make(chan error, 1)
-		go func() {
-			conn, err := listener.Accept()
-			if err != nil {
-				errChan <- err
-			}
-			connChan <- conn
-		}()
+		accept()
 		select {
 		case conn := <-connChan:
 			retries = 0
-			go handleConnection(serv.ConfigFile.Content.Executable, serv.ConfigFile.Path, serv.ExtensionName, browser, serv.ListenIn, conn, stopConnectionSignal, &connectionWait)
+			go handleConnection(connectionsCtx, serv, conn, nil, stopConnectionSignal, &connectionWait)
+
+		case routed := <-serv.routed:
+			go handleConnection(connectionsCtx, serv, routed.conn, &routed.hello, stopConnectionSignal, &connectionWait)
 
 		case err := <-errChan:
 			if retries < 5 {
-				logs.Warn("retrying connection for", serv.ExtensionName, err)
+				log.Warn("retrying connection for", serv.ExtensionName, err)
 				retries++
 				continue
 			} else {
 				err = fmt.Errorf("failed to establish connection for %s: %w", serv.ExtensionName, err)
-				logs.Error(err)
+				log.Error(err)
 				return err
 			}
 
 		case <-serv.stop:
-			logs.Info("closing server for", serv.ExtensionName)
+			log.Info("closing server for", serv.ExtensionName)
+			stopConnections(errServerStopping)
 			for {
 				select {
 				case stopConnectionSignal <- true:
 					continue
 				default:
-					logs.Debug("Waiting for all connections to exit", serv.ExtensionName)
+					log.Debug("Waiting for all connections to exit", serv.ExtensionName)
 					connectionWait.Wait()
-					logs.Debug("all connections exited", serv.ExtensionName)
+					log.Debug("all connections exited", serv.ExtensionName)
 					return nil
 				}
 			}
 		}
 	}
 }
+
+// loadAppSettings is called for every connection, so changed settings apply to the next host without restarting the server.
+// Warm hosts that were started with other settings are replaced.
+func (serv *Server) loadAppSettings(log *logs.Logger) settings.AppSettings {
+	appSettings := settings.GetAppSettings(serv.ConfigFile.GetBrowser(), serv.ConfigFile.Name())
+
+	serv.settingsMutex.Lock()
+	defer serv.settingsMutex.Unlock()
+	if serv.settingsLoaded && !hostSettingsChanged(serv.poolSettings, appSettings) {
+		return appSettings
+	}
+	if serv.settingsLoaded {
+		log.Info("settings of", serv.ConfigFile.Name(), "changed, new hosts for", serv.ExtensionName, "are started with them")
+	}
+	serv.settingsLoaded = true
+	serv.poolSettings = appSettings
+
+	err := CheckHostExecutable(serv.ConfigFile, appSettings)
+	if err != nil {
+		log.Error(fmt.Errorf("host of %s can't be started: %w", serv.ConfigFile.Name(), err))
+	}
+
+	if serv.warmPool != nil {
+		serv.warmPool.close()
+		serv.warmPool = nil
+	}
+	if appSettings.PrewarmHosts > 0 {
+		serv.warmPool = serv.newWarmPool(log, appSettings)
+	}
+	return appSettings
+}
+
+// hostSettingsChanged ignores the decisions about extensions, they don't change how hosts are started.
+func hostSettingsChanged(previous settings.AppSettings, current settings.AppSettings) bool {
+	previous.ExtensionDecisions = nil
+	current.ExtensionDecisions = nil
+	return !reflect.DeepEqual(previous, current)
+}
+
+func (serv *Server) newWarmPool(log *logs.Logger, appSettings settings.AppSettings) *warmPool {
+	defaultArgs := serv.defaultHostArgs()
+	newCommand := func() (*exec.Cmd, *config.VerifiedExecutable, error) {
+		err := settings.SystemPolicyError()
+		if err != nil {
+			return nil, nil, err
+		}
+		executable, err := serv.verifyExecutable()
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd, err := serv.newHostCommand(appSettings, defaultArgs)
+		if err != nil && executable != nil {
+			executable.Close()
+		}
+		return cmd, executable, err
+	}
+	acquireSlot := func() error { return serv.acquireHostSlot(appSettings.MaxProcesses) }
+	return newWarmPool(serv.ExtensionName, log, appSettings.PrewarmHosts, appSettings.GetPrewarmIdleTimeout(), defaultArgs, newCommand, newHostLimits(appSettings), acquireSlot, serv.releaseHostSlot)
+}
+
+func (serv *Server) closeWarmPool() {
+	serv.settingsMutex.Lock()
+	defer serv.settingsMutex.Unlock()
+	if serv.warmPool != nil {
+		serv.warmPool.close()
+		serv.warmPool = nil
+	}
+}
+
+// defaultHostArgs are the arguments browsers pass to the host without the flags that change with every launch,
+// prewarmed hosts are started with them.
+func (serv *Server) defaultHostArgs() []string {
+	browser := serv.ConfigFile.GetBrowser()
+	browserArgs := util.BrowserArguments{Flavour: browser.GetFlavour(), Extension: serv.ExtensionName}
+	return browserArgs.ArgumentsWithoutFlags(serv.ConfigFile.Path)
+}
+
+// takeWarmHost ignores flags like --parent-window= that change with every launch,
+// otherwise no warm host would ever match for chromium based browsers.
+// A warm host was started before the browser passed them, so it doesn't get them.
+func (serv *Server) takeWarmHost(browserArgs util.BrowserArguments) *hostProcess {
+	serv.settingsMutex.Lock()
+	defer serv.settingsMutex.Unlock()
+	if serv.warmPool == nil {
+		return nil
+	}
+	return serv.warmPool.take(browserArgs.ArgumentsWithoutFlags(serv.ConfigFile.Path))
+}
+
+// refillWarmPool starts warm hosts that could not be started before because all host slots were used.
+func (serv *Server) refillWarmPool() {
+	serv.settingsMutex.Lock()
+	defer serv.settingsMutex.Unlock()
+	if serv.warmPool == nil {
+		return
+	}
+	go serv.warmPool.refill()
+}
+
+// acquireHostSlot reports if another host can be started without going over the process limit of the app, 0 is unlimited.
+// The limit comes from the settings of the connection, so a changed limit applies to the next host.
+func (serv *Server) acquireHostSlot(maxProcesses int) error {
+	serv.hostsMutex.Lock()
+	defer serv.hostsMutex.Unlock()
+	if maxProcesses > 0 && serv.runningHosts >= maxProcesses {
+		return fmt.Errorf("%s already has the maximum of %d running hosts", serv.ExtensionName, maxProcesses)
+	}
+	serv.runningHosts++
+	return nil
+}
+
+func (serv *Server) releaseHostSlot() {
+	serv.hostsMutex.Lock()
+	defer serv.hostsMutex.Unlock()
+	serv.runningHosts--
+}
+
+// closeRouted stops routing connections to the server and closes the ones that were not handled anymore.
+func (serv *Server) closeRouted() {
+	unregisterMultiplexed(serv)
+	for {
+		select {
+		case routed := <-serv.routed:
+			routed.conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// AppSettings are the settings of a single app for one browser.
+type AppSettings struct {
+	// Name is the name of the config file of the app.
+	Name string `mapstructure:"name" toml:"name"`
+	// PrewarmHosts is the number of host processes kept running so new connections don't wait for the host to start.
+	PrewarmHosts int `mapstructure:"prewarmHosts" toml:"prewarmHosts,omitempty"`
+	// PrewarmIdleTimeout stops prewarmed hosts that were not used for this long.
+	PrewarmIdleTimeout string `mapstructure:"prewarmIdleTimeout" toml:"prewarmIdleTimeout,omitempty"`
+	// IdleTimeout closes connections without messages in either direction for this long.
+	IdleTimeout string `mapstructure:"idleTimeout" toml:"idleTimeout,omitempty"`
+	// MaxLifetime closes connections that are open for longer than this.
+	MaxLifetime string `mapstructure:"maxLifetime" toml:"maxLifetime,omitempty"`
+	// MaxOpenFiles limits the number of files a host can open.
+	MaxOpenFiles int `mapstructure:"maxOpenFiles" toml:"maxOpenFiles,omitempty"`
+	// MaxMemoryMiB limits the address space of a host.
+	MaxMemoryMiB int `mapstructure:"maxMemoryMiB" toml:"maxMemoryMiB,omitempty"`
+	// CPUTime limits the CPU time a host can use.
+	CPUTime string `mapstructure:"cpuTime" toml:"cpuTime,omitempty"`
+	// Niceness is the scheduling priority of hosts, from 0 to 19.
+	Niceness int `mapstructure:"niceness" toml:"niceness,omitempty"`
+	// MaxProcesses limits how many hosts can run at the same time for each extension.
+	MaxProcesses int `mapstructure:"maxProcesses" toml:"maxProcesses,omitempty"`
+	// Env is added to the environment of hosts, every entry has the form KEY=VALUE.
+	Env []string `mapstructure:"env" toml:"env,omitempty"`
+	// ClearEnv starts hosts only with the variables from Env instead of the environment of the server.
+	ClearEnv bool `mapstructure:"clearEnv" toml:"clearEnv,omitempty"`
+	// ExtraArgs are appended to the arguments the browser passes to hosts.
+	ExtraArgs []string `mapstructure:"extraArgs" toml:"extraArgs,omitempty"`
+	// WorkingDir replaces the folder of the executable as working directory of hosts.
+	WorkingDir string `mapstructure:"workingDir" toml:"workingDir,omitempty"`
+	// Wrapper starts hosts through another command, either a preset or a template like "distrobox-enter -n dev -- {exec} {args}".
+	Wrapper string `mapstructure:"wrapper" toml:"wrapper,omitempty"`
+	// Container replaces {container} in the wrapper.
+	Container string `mapstructure:"container" toml:"container,omitempty"`
+	// PeerVerification decides what happens to clients that can't be verified to run inside the flatpak of the browser.
+	PeerVerification string `mapstructure:"peerVerification" toml:"peerVerification,omitempty"`
+	// ExecutableSHA256, ExecutableSize and ExecutableOwner pin the executable the user approved.
+	ExecutableSHA256 string `mapstructure:"executableSha256" toml:"executableSha256,omitempty"`
+	ExecutableSize   int64  `mapstructure:"executableSize" toml:"executableSize,omitempty"`
+	ExecutableOwner  int    `mapstructure:"executableOwner" toml:"executableOwner,omitempty"`
+	// AllowedExtensions limits which of the extensions listed in the manifest can use the app.
+	// Entries are IDs, glob patterns like "*@example.org" or regular expressions starting with "re:".
+	AllowedExtensions []string `mapstructure:"allowedExtensions" toml:"allowedExtensions,omitempty"`
+	// BlockedExtensions can't use the app even if they are allowed, entries are written like in AllowedExtensions.
+	BlockedExtensions []string `mapstructure:"blockedExtensions" toml:"blockedExtensions,omitempty"`
+	// EnabledUntil is the time in RFC 3339 format when the app is disabled automatically.
+	EnabledUntil string `mapstructure:"enabledUntil" toml:"enabledUntil,omitempty"`
+	// ExtensionDecisions remember the answers to approval prompts.
+	ExtensionDecisions []ExtensionDecision `mapstructure:"extensionDecisions" toml:"extensionDecisions,omitempty"`
+}
+
+// ExtensionDecision is the remembered answer to the approval prompt of an extension.
+type ExtensionDecision struct {
+	Extension string `mapstructure:"extension" toml:"extension"`
+	Decision  string `mapstructure:"decision" toml:"decision"`
+}
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+const (
+	// PeerVerificationPermissive logs clients outside of the flatpak of the browser but allows them.
+	PeerVerificationPermissive = "permissive"
+	// PeerVerificationStrict rejects clients outside of the flatpak of the browser.
+	PeerVerificationStrict = "strict"
+)
+
+const DefaultPrewarmIdleTimeout = 10 * time.Minute
+
+func (appSettings *AppSettings) GetPrewarmIdleTimeout() time.Duration {
+	return parseDurationSetting(appSettings.PrewarmIdleTimeout, DefaultPrewarmIdleTimeout)
+}
+
+// GetIdleTimeout returns 0 if connections never time out.
+func (appSettings *AppSettings) GetIdleTimeout() time.Duration {
+	return parseDurationSetting(appSettings.IdleTimeout, 0)
+}
+
+// GetMaxLifetime returns 0 if connections can stay open forever.
+func (appSettings *AppSettings) GetMaxLifetime() time.Duration {
+	return parseDurationSetting(appSettings.MaxLifetime, 0)
+}
+
+// GetCPUTime returns 0 if the CPU time is not limited.
+func (appSettings *AppSettings) GetCPUTime() time.Duration {
+	return parseDurationSetting(appSettings.CPUTime, 0)
+}
+
+// GetEnabledUntil returns the zero time if the app stays enabled until it is disabled by the user.
+func (appSettings *AppSettings) GetEnabledUntil() time.Time {
+	enabledUntil, err := time.Parse(time.RFC3339, appSettings.EnabledUntil)
+	if err != nil {
+		return time.Time{}
+	}
+	return enabledUntil
+}
+
+// SetEnabledUntil removes the expiry if enabledUntil is the zero time.
+func (appSettings *AppSettings) SetEnabledUntil(enabledUntil time.Time) {
+	if enabledUntil.IsZero() {
+		appSettings.EnabledUntil = ""
+		return
+	}
+	appSettings.EnabledUntil = enabledUntil.Format(time.RFC3339)
+}
+
+// GetExtensionDecision returns an empty string if the user was not asked about the extension yet.
+func (appSettings *AppSettings) GetExtensionDecision(extension string) string {
+	extension = util.NormalizeExtensionName(extension)
+	index := slices.IndexFunc(appSettings.ExtensionDecisions, func(element ExtensionDecision) bool { return element.Extension == extension })
+	if index == -1 {
+		return ""
+	}
+	return appSettings.ExtensionDecisions[index].Decision
+}
+
+// SetExtensionDecision replaces the decision about an extension, an empty decision revokes it.
+func (appSettings *AppSettings) SetExtensionDecision(extension string, decision string) {
+	extension = util.NormalizeExtensionName(extension)
+	appSettings.ExtensionDecisions = slices.DeleteFunc(appSettings.ExtensionDecisions, func(element ExtensionDecision) bool { return element.Extension == extension })
+	if decision != "" {
+		appSettings.ExtensionDecisions = append(appSettings.ExtensionDecisions, ExtensionDecision{Extension: extension, Decision: decision})
+	}
+}
+
+// Validate reports settings that can't be used.
+func (appSettings *AppSettings) Validate() error {
+	return errors.Join(
+		validateNotNegative("prewarmHosts", appSettings.PrewarmHosts),
+		validateNotNegative("maxOpenFiles", appSettings.MaxOpenFiles),
+		validateNotNegative("maxMemoryMiB", appSettings.MaxMemoryMiB),
+		validateNotNegative("maxProcesses", appSettings.MaxProcesses),
+		validateNiceness(appSettings.Niceness),
+		validateDurationSetting("cpuTime", appSettings.CPUTime),
+		validateEnv(appSettings.Env),
+		validateWorkingDir(appSettings.WorkingDir),
+		validateWrapper(appSettings.Wrapper, appSettings.GetWrapperTemplate(), appSettings.Container),
+		validatePeerVerification(appSettings.PeerVerification),
+		validateExtensionDecisions(appSettings.ExtensionDecisions),
+		validateEnabledUntil(appSettings.EnabledUntil),
+		validateExtensionRules("allowedExtensions", appSettings.AllowedExtensions),
+		validateExtensionRules("blockedExtensions", appSettings.BlockedExtensions),
+		validateDurationSetting("prewarmIdleTimeout", appSettings.PrewarmIdleTimeout),
+		validateDurationSetting("idleTimeout", appSettings.IdleTimeout),
+		validateDurationSetting("maxLifetime", appSettings.MaxLifetime),
+	)
+}
+
+func GetAppSettings(browser util.Browser, nativeConfigFileName string) AppSettings {
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	appSettings := userAppSettings(readAllAppSettings(browser), nativeConfigFileName)
+
+	// the policy was validated when it was loaded, it is never ignored
+	policySettings := appSettings
+	err := loadSystemPolicy().applyToAppSettings(browser, &policySettings)
+	if err == nil {
+		err = policySettings.Validate()
+	}
+	if err != nil {
+		logs.Error(fmt.Errorf("settings of %s don't fit the system policy: %w", nativeConfigFileName, err))
+	}
+	return policySettings
+}
+
+// userAppSettings returns the settings of the user without the system policy.
+func userAppSettings(allAppSettings []AppSettings, nativeConfigFileName string) AppSettings {
+	index := slices.IndexFunc(allAppSettings, func(element AppSettings) bool { return element.Name == nativeConfigFileName })
+	if index == -1 {
+		return AppSettings{Name: nativeConfigFileName}
+	}
+
+	appSettings := allAppSettings[index]
+	err := appSettings.Validate()
+	if err != nil {
+		logs.Error(fmt.Errorf("ignoring invalid settings of %s: %w", nativeConfigFileName, err))
+		return AppSettings{Name: nativeConfigFileName}
+	}
+	return appSettings
+}
+
+func SetAppSettings(browser util.Browser, appSettings AppSettings) error {
+	err := appSettings.Validate()
+	if err != nil {
+		return err
+	}
+
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	allAppSettings := readAllAppSettings(browser)
+	err = loadSystemPolicy().checkLockedAppSettings(browser, &appSettings, userAppSettings(allAppSettings, appSettings.Name))
+	if err != nil {
+		return err
+	}
+	allAppSettings = slices.DeleteFunc(allAppSettings, func(element AppSettings) bool { return element.Name == appSettings.Name })
+	allAppSettings = append(allAppSettings, appSettings)
+
+	return writeSetting(string(browser)+".apps", allAppSettings)
+}
+
+func readAllAppSettings(browser util.Browser) []AppSettings {
+	allAppSettings := []AppSettings{}
+	err := viper.UnmarshalKey(string(browser)+".apps", &allAppSettings)
+	if err != nil {
+		logs.Error(fmt.Errorf("could not read app settings of %s: %w", browser, err))
+	}
+	return allAppSettings
+}
+
+func parseDurationSetting(value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
+
+func validateNotNegative(name string, value int) error {
+	if value < 0 {
+		return fmt.Errorf("%s can't be negative: %d", name, value)
+	}
+	return nil
+}
+
+// validateNiceness only allows lowering the priority, raising it needs privileges.
+func validateNiceness(niceness int) error {
+	if niceness < 0 || niceness > 19 {
+		return fmt.Errorf("niceness has to be between 0 and 19: %d", niceness)
+	}
+	return nil
+}
+
+func validateEnv(env []string) error {
+	for _, variable := range env {
+		key, _, found := strings.Cut(variable, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \t\n\x00") {
+			return fmt.Errorf("env has to contain entries like KEY=VALUE: %q", variable)
+		}
+	}
+	return nil
+}
+
+func validateWorkingDir(workingDir string) error {
+	if workingDir != "" && !filepath.IsAbs(workingDir) {
+		return fmt.Errorf("workingDir has to be an absolute path: %s", workingDir)
+	}
+	return nil
+}
+
+func validatePeerVerification(mode string) error {
+	if mode != "" && mode != PeerVerificationPermissive && mode != PeerVerificationStrict {
+		return fmt.Errorf("peerVerification has to be %s or %s: %s", PeerVerificationPermissive, PeerVerificationStrict, mode)
+	}
+	return nil
+}
+
+func validateEnabledUntil(enabledUntil string) error {
+	if enabledUntil == "" {
+		return nil
+	}
+	_, err := time.Parse(time.RFC3339, enabledUntil)
+	if err != nil {
+		return fmt.Errorf("enabledUntil is not a valid time: %w", err)
+	}
+	return nil
+}
+
+func validateExtensionDecisions(decisions []ExtensionDecision) error {
+	for _, decision := range decisions {
+		if decision.Decision != DecisionAllow && decision.Decision != DecisionDeny {
+			return fmt.Errorf("decision about %s has to be %s or %s: %s", decision.Extension, DecisionAllow, DecisionDeny, decision.Decision)
+		}
+	}
+	return nil
+}
+
+func validateDurationSetting(name string, value string) error {
+	if value == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid duration: %w", name, err)
+	}
+	if duration < 0 {
+		return fmt.Errorf("%s can't be negative: %s", name, value)
+	}
+	return nil
+}
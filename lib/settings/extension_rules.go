@@ -0,0 +1,63 @@
+package settings
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// regexRulePrefix marks extension rules that are regular expressions instead of glob patterns.
+const regexRulePrefix = "re:"
+
+// ExtensionAllowed reports if an extension listed in the manifest of the app can use it.
+// Blocked extensions are never allowed, without allow rules all other extensions are.
+func (appSettings *AppSettings) ExtensionAllowed(extension string) bool {
+	extension = util.NormalizeExtensionName(extension)
+	if matchesAnyExtensionRule(appSettings.BlockedExtensions, extension) {
+		return false
+	}
+	if len(appSettings.AllowedExtensions) == 0 {
+		return true
+	}
+	return matchesAnyExtensionRule(appSettings.AllowedExtensions, extension)
+}
+
+func matchesAnyExtensionRule(rules []string, extension string) bool {
+	for _, rule := range rules {
+		if matchesExtensionRule(rule, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesExtensionRule matches a rule against the whole ID of an extension.
+// Rules are IDs, glob patterns like "*@example.org" or regular expressions starting with "re:".
+func matchesExtensionRule(rule string, extension string) bool {
+	if expression, isRegex := strings.CutPrefix(rule, regexRulePrefix); isRegex {
+		compiled, err := regexp.Compile("^(?:" + expression + ")$")
+		return err == nil && compiled.MatchString(extension)
+	}
+	matched, err := path.Match(util.NormalizeExtensionName(rule), extension)
+	return err == nil && matched
+}
+
+func validateExtensionRules(name string, rules []string) error {
+	for _, rule := range rules {
+		if expression, isRegex := strings.CutPrefix(rule, regexRulePrefix); isRegex {
+			_, err := regexp.Compile(expression)
+			if err != nil {
+				return fmt.Errorf("%s contains an invalid regular expression %q: %w", name, rule, err)
+			}
+			continue
+		}
+		_, err := path.Match(rule, "")
+		if err != nil || rule == "" {
+			return fmt.Errorf("%s contains an invalid pattern %q", name, rule)
+		}
+	}
+	return nil
+}
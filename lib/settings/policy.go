@@ -0,0 +1,306 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+	"github.com/taukakao/browser-glue/lib/logs"
+	"github.com/taukakao/browser-glue/lib/util"
+)
+
+// ErrLocked is returned when a setting is locked by the system policy.
+var ErrLocked = errors.New("locked by the system policy")
+
+// ErrInvalidPolicy is returned while the policy file exists but can't be used, all apps count as forbidden until it is fixed.
+var ErrInvalidPolicy = errors.New("the system policy is invalid, no app can be enabled until the administrator fixes it")
+
+// SystemPolicyPath is the read-only policy of the administrator, user settings only apply where it allows them.
+const SystemPolicyPath = "/etc/browser-glue/policy.toml"
+
+const (
+	// HostForced apps are always enabled.
+	HostForced = "forced"
+	// HostForbidden apps can't be enabled.
+	HostForbidden = "forbidden"
+)
+
+// systemPolicy is the content of the policy file.
+type systemPolicy struct {
+	// DisableListenIn prevents printing the messages between extensions and hosts.
+	DisableListenIn bool `mapstructure:"disableListenIn"`
+	// Server locks the global settings it contains to its values.
+	Server map[string]any `mapstructure:"server"`
+	Hosts  []hostPolicy   `mapstructure:"hosts"`
+
+	// err is set if the policy file exists but can't be used
+	err error
+}
+
+// serverPolicy are the keys of the [server] table the policy can lock.
+type serverPolicy struct {
+	MultiplexSockets bool     `mapstructure:"multiplexSockets"`
+	ApprovalPrompts  bool     `mapstructure:"approvalPrompts"`
+	Approvers        []string `mapstructure:"approvers"`
+}
+
+type hostPolicy struct {
+	// Name is the name of the config file of the app.
+	Name string `mapstructure:"name"`
+	// Browser limits the entry to one browser, empty matches all.
+	Browser string `mapstructure:"browser"`
+	// State is either empty, HostForced or HostForbidden.
+	State string `mapstructure:"state"`
+	// Settings locks the app settings it contains to its values, for example allowedExtensions.
+	Settings map[string]any `mapstructure:"settings"`
+}
+
+// SystemPolicyError returns an error wrapping ErrInvalidPolicy if the policy file exists but can't be used.
+func SystemPolicyError() error {
+	return loadSystemPolicy().err
+}
+
+// GetHostPolicyState returns HostForced, HostForbidden or an empty string if the user decides.
+// All apps are forbidden while the policy is invalid.
+func GetHostPolicyState(browser util.Browser, nativeConfigFileName string) string {
+	policy := loadSystemPolicy()
+	if policy.err != nil {
+		return HostForbidden
+	}
+	for _, host := range policy.matchingHosts(browser, nativeConfigFileName) {
+		if host.State != "" {
+			return host.State
+		}
+	}
+	return ""
+}
+
+// LockedAppSettings returns the keys of the app settings that are set by the system policy.
+func LockedAppSettings(browser util.Browser, nativeConfigFileName string) []string {
+	locked := []string{}
+	for _, host := range loadSystemPolicy().matchingHosts(browser, nativeConfigFileName) {
+		for key := range host.Settings {
+			canonicalKey := appSettingKey(key)
+			if canonicalKey == "name" {
+				continue
+			}
+			if !slices.Contains(locked, canonicalKey) {
+				locked = append(locked, canonicalKey)
+			}
+		}
+	}
+	slices.Sort(locked)
+	return locked
+}
+
+// IsServerSettingLocked reports if a key in the [server] table is set by the system policy.
+func IsServerSettingLocked(key string) bool {
+	_, locked := loadSystemPolicy().serverValue(key)
+	return locked
+}
+
+// lockedServerSetting returns the value of a key in the [server] table of the system policy.
+func lockedServerSetting[T any](key string) (T, bool) {
+	var setting T
+	value, locked := loadSystemPolicy().serverValue(key)
+	if !locked {
+		return setting, false
+	}
+	err := mapstructure.WeakDecode(value, &setting)
+	if err != nil {
+		logs.Error(fmt.Errorf("invalid value for server.%s in the system policy: %w", key, err))
+		return setting, false
+	}
+	return setting, true
+}
+
+// ListenInDisabledByPolicy reports if the system policy forbids printing messages, which it does while it is invalid.
+func ListenInDisabledByPolicy() bool {
+	policy := loadSystemPolicy()
+	return policy.DisableListenIn || policy.err != nil
+}
+
+func (policy systemPolicy) matchingHosts(browser util.Browser, nativeConfigFileName string) []hostPolicy {
+	hosts := []hostPolicy{}
+	for _, host := range policy.Hosts {
+		if host.Name == nativeConfigFileName && (host.Browser == "" || host.Browser == string(browser)) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func (policy systemPolicy) serverValue(key string) (any, bool) {
+	for policyKey, value := range policy.Server {
+		if strings.EqualFold(policyKey, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// applyToAppSettings overwrites the settings locked by the policy.
+func (policy systemPolicy) applyToAppSettings(browser util.Browser, appSettings *AppSettings) error {
+	name := appSettings.Name
+	// the name identifies the settings and can't be changed by the policy
+	defer func() { appSettings.Name = name }()
+
+	for _, host := range policy.matchingHosts(browser, name) {
+		err := mapstructure.WeakDecode(host.Settings, appSettings)
+		if err != nil {
+			return fmt.Errorf("invalid settings for %s in the system policy: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkLockedAppSettings returns ErrLocked if a locked setting differs from the policy,
+// otherwise the locked settings are reset to the user settings so the policy is not written to the user settings.
+func (policy systemPolicy) checkLockedAppSettings(browser util.Browser, appSettings *AppSettings, userSettings AppSettings) error {
+	lockedKeys := LockedAppSettings(browser, appSettings.Name)
+	if len(lockedKeys) == 0 {
+		return nil
+	}
+
+	policySettings := userSettings
+	err := policy.applyToAppSettings(browser, &policySettings)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range lockedKeys {
+		field := appSettingField(appSettings, key)
+		if !field.IsValid() {
+			continue
+		}
+		if !reflect.DeepEqual(field.Interface(), appSettingField(&policySettings, key).Interface()) {
+			return fmt.Errorf("%s is %w", key, ErrLocked)
+		}
+		field.Set(appSettingField(&userSettings, key))
+	}
+	return nil
+}
+
+// appSettingKey returns the key of the app setting as written in AppSettings, keys in the policy are read in lower case.
+func appSettingKey(key string) string {
+	settingsType := reflect.TypeFor[AppSettings]()
+	for index := range settingsType.NumField() {
+		tag := settingsType.Field(index).Tag.Get("mapstructure")
+		if strings.EqualFold(tag, key) {
+			return tag
+		}
+	}
+	return key
+}
+
+func appSettingField(appSettings *AppSettings, key string) reflect.Value {
+	value := reflect.ValueOf(appSettings).Elem()
+	for index := range value.NumField() {
+		if value.Type().Field(index).Tag.Get("mapstructure") == key {
+			return value.Field(index)
+		}
+	}
+	return reflect.Value{}
+}
+
+var cachedPolicy struct {
+	sync.Mutex
+	policy  systemPolicy
+	modTime time.Time
+	loaded  bool
+}
+
+// loadSystemPolicy reads the policy file again when it changed.
+// A policy that can't be read or is invalid is returned with err set and nothing else, it is never ignored.
+func loadSystemPolicy() systemPolicy {
+	cachedPolicy.Lock()
+	defer cachedPolicy.Unlock()
+
+	info, err := os.Stat(SystemPolicyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		cachedPolicy.policy = systemPolicy{}
+		cachedPolicy.loaded = false
+		return cachedPolicy.policy
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: can't read %s: %w", ErrInvalidPolicy, SystemPolicyPath, err)
+		if cachedPolicy.policy.err == nil || cachedPolicy.policy.err.Error() != err.Error() {
+			logs.Error(err)
+		}
+		cachedPolicy.policy = systemPolicy{err: err}
+		cachedPolicy.loaded = false
+		return cachedPolicy.policy
+	}
+	if cachedPolicy.loaded && info.ModTime().Equal(cachedPolicy.modTime) {
+		return cachedPolicy.policy
+	}
+
+	policyViper := viper.New()
+	policyViper.SetConfigFile(SystemPolicyPath)
+	policyViper.SetConfigType("toml")
+	policy := systemPolicy{}
+	err = policyViper.ReadInConfig()
+	if err == nil {
+		err = policyViper.Unmarshal(&policy)
+	}
+	if err == nil {
+		err = policy.validate()
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrInvalidPolicy, SystemPolicyPath, err)
+		logs.Error(err)
+		policy = systemPolicy{err: err}
+	} else {
+		logs.Debug("loaded system policy", SystemPolicyPath)
+	}
+
+	cachedPolicy.policy = policy
+	cachedPolicy.modTime = info.ModTime()
+	cachedPolicy.loaded = true
+	return cachedPolicy.policy
+}
+
+// validate checks the parts of the policy that are only read when they are used,
+// so a mistake is found when the policy is loaded instead of unlocking a setting later.
+func (policy systemPolicy) validate() error {
+	serverSettings := serverPolicy{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{WeaklyTypedInput: true, ErrorUnused: true, Result: &serverSettings})
+	if err == nil {
+		err = decoder.Decode(policy.Server)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid [server] table: %w", err)
+	}
+
+	for _, host := range policy.Hosts {
+		if host.Name == "" {
+			return errors.New("a host has no name")
+		}
+		if host.State != "" && host.State != HostForced && host.State != HostForbidden {
+			return fmt.Errorf("state of %s is %q, it can only be %q or %q", host.Name, host.State, HostForced, HostForbidden)
+		}
+		if host.Browser != "" && !slices.Contains(util.GetAllBrowsers(), util.Browser(host.Browser)) {
+			return fmt.Errorf("browser %q of %s is not known", host.Browser, host.Name)
+		}
+		hostSettings := AppSettings{Name: host.Name}
+		err := policy.applyToAppSettings(util.Browser(host.Browser), &hostSettings)
+		if hostSettings.Container == "" {
+			// a wrapper locked by the policy can use the container from the user settings
+			hostSettings.Container = "container"
+		}
+		if err == nil {
+			err = hostSettings.Validate()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
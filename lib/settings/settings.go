@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
 	"sync"
 
@@ -20,14 +21,32 @@ func SubscribeToChanges(subscription chan struct{}) {
 	subscribers = append(subscribers, subscription)
 }
 
+// EnabledNativeConfigFiles includes the apps forced by the system policy and leaves out the forbidden ones.
 func EnabledNativeConfigFiles(browser util.Browser) []string {
 	viperMutex.Lock()
 	defer viperMutex.Unlock()
 
-	return viper.GetStringSlice(string(browser) + ".enabledConfigs")
+	enabled := viper.GetStringSlice(string(browser) + ".enabledConfigs")
+	enabled = slices.DeleteFunc(enabled, func(name string) bool { return GetHostPolicyState(browser, name) == HostForbidden })
+	for _, host := range loadSystemPolicy().Hosts {
+		forced := host.State == HostForced && (host.Browser == "" || host.Browser == string(browser))
+		if forced && !slices.Contains(enabled, host.Name) {
+			enabled = append(enabled, host.Name)
+		}
+	}
+	return enabled
 }
 
 func SetNativeConfigFileEnabled(browser util.Browser, nativeConfigFilePath string, enable bool) error {
+	err := SystemPolicyError()
+	if err != nil && enable {
+		return err
+	}
+	state := GetHostPolicyState(browser, nativeConfigFilePath)
+	if (state == HostForced && !enable) || (state == HostForbidden && enable) {
+		return fmt.Errorf("%s is %s and %w", nativeConfigFilePath, state, ErrLocked)
+	}
+
 	viperMutex.Lock()
 	defer viperMutex.Unlock()
 
@@ -43,8 +62,108 @@ func SetNativeConfigFileEnabled(browser util.Browser, nativeConfigFilePath strin
 		allEnabled = slices.DeleteFunc(allEnabled, func(element string) bool { return element == nativeConfigFilePath })
 	}
 
-	viper.Set(string(browser)+".enabledConfigs", allEnabled)
-	return viper.WriteConfig()
+	return writeSetting(string(browser)+".enabledConfigs", allEnabled)
+}
+
+// writeSetting changes a single setting in the config file and reads it again.
+// Values set on the global viper instance would hide changes other processes make to the file later.
+func writeSetting(key string, value any) error {
+	configPath := viper.ConfigFileUsed()
+
+	fileViper := viper.New()
+	fileViper.SetConfigFile(configPath)
+	fileViper.SetConfigType("toml")
+	err := fileViper.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	fileViper.Set(key, value)
+
+	err = writeConfig(fileViper, configPath)
+	if err != nil {
+		return err
+	}
+	return viper.ReadInConfig()
+}
+
+// writeConfig replaces the config file instead of truncating it,
+// otherwise the watcher can reload the empty file before viper writes the settings into it.
+func writeConfig(fileViper *viper.Viper, configPath string) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(configPath), ".config-*"+filepath.Ext(configPath))
+	if err != nil {
+		return fmt.Errorf("could not create temporary config file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+
+	err = fileViper.WriteConfigAs(tempPath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, configPath)
+}
+
+// MultiplexSocketsEnabled reports if each browser should get a single socket for all apps.
+func MultiplexSocketsEnabled() bool {
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	multiplex, locked := lockedServerSetting[bool]("multiplexSockets")
+	if locked {
+		return multiplex
+	}
+	return viper.GetBool("server.multiplexSockets")
+}
+
+// ApprovalPromptsEnabled reports if extensions have to be approved before they can connect to a host the first time.
+func ApprovalPromptsEnabled() bool {
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	enabled, locked := lockedServerSetting[bool]("approvalPrompts")
+	if locked {
+		return enabled
+	}
+	return viper.GetBool("server.approvalPrompts")
+}
+
+// LoggingConfig reads the [logging] table, invalid levels are ignored with a warning.
+func LoggingConfig() logs.Config {
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	config := logs.Config{
+		Format: viper.GetString("logging.format"),
+		File:   viper.GetBool("logging.file"),
+		Color:  viper.GetString("logging.color"),
+	}
+	if viper.IsSet("logging.level") {
+		level, err := logs.ParseLogLevel(viper.GetString("logging.level"))
+		if err != nil {
+			logs.Warn("ignoring logging.level in the config:", err)
+		}
+		config.Level = level
+	}
+	return config
+}
+
+// DefaultApprovers are asked in this order until one of them answers.
+var DefaultApprovers = []string{"policy", "gui", "notification", "terminal"}
+
+// Approvers returns the names of the approvers that can answer approval prompts.
+func Approvers() []string {
+	viperMutex.Lock()
+	defer viperMutex.Unlock()
+
+	approvers, locked := lockedServerSetting[[]string]("approvers")
+	if locked {
+		return approvers
+	}
+	if !viper.IsSet("server.approvers") {
+		return DefaultApprovers
+	}
+	return viper.GetStringSlice("server.approvers")
 }
 
 var viperMutex sync.Mutex
@@ -61,14 +180,15 @@ func init() {
 	viper.SetConfigType("toml")
 	userConfigDir := util.GetCustomUserConfigDir()
 	viper.AddConfigPath(userConfigDir)
+	// the config contains the approved executables, so only the user may change it
+	err = util.MkdirSecure(userConfigDir, 0o700)
+	if err != nil {
+		err = fmt.Errorf("can't create user config dir %s: %w", userConfigDir, err)
+		logs.Error(err)
+	}
 	err = viper.ReadInConfig()
 	if errors.As(err, &viper.ConfigFileNotFoundError{}) {
 		logs.Info("creating config file", viper.ConfigFileUsed())
-		err = os.MkdirAll(userConfigDir, 0o775)
-		if err != nil {
-			err = fmt.Errorf("can't create user config dir %s: %w", userConfigDir, err)
-			logs.Error(err)
-		}
 		err = viper.SafeWriteConfig()
 		if err != nil {
 			err = fmt.Errorf("could not write config file: %w", err)
@@ -0,0 +1,67 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WrapperPresets are templates for common container tools.
+var WrapperPresets = map[string]string{
+	"toolbox":   "toolbox run --container {container} {exec} {args}",
+	"distrobox": "distrobox-enter --name {container} -- {exec} {args}",
+	"podman":    "podman exec --interactive {container} {exec} {args}",
+}
+
+// GetWrapperTemplate resolves presets, an empty template means hosts are started directly.
+func (appSettings *AppSettings) GetWrapperTemplate() string {
+	preset, ok := WrapperPresets[appSettings.Wrapper]
+	if ok {
+		return preset
+	}
+	return appSettings.Wrapper
+}
+
+// WrapCommand returns the command line that starts the executable through the wrapper.
+// Arguments are added at the end if the template doesn't contain {args}.
+func (appSettings *AppSettings) WrapCommand(executable string, args []string) []string {
+	template := appSettings.GetWrapperTemplate()
+	if template == "" {
+		return append([]string{executable}, args...)
+	}
+
+	fields := strings.Fields(template)
+	commandLine := make([]string, 0, len(fields)+len(args))
+	for _, field := range fields {
+		switch field {
+		case "{exec}":
+			commandLine = append(commandLine, executable)
+		case "{args}":
+			commandLine = append(commandLine, args...)
+		default:
+			commandLine = append(commandLine, strings.ReplaceAll(field, "{container}", appSettings.Container))
+		}
+	}
+	if !slices.Contains(fields, "{args}") {
+		commandLine = append(commandLine, args...)
+	}
+	return commandLine
+}
+
+func validateWrapper(wrapper string, template string, container string) error {
+	if wrapper == "" {
+		return nil
+	}
+	fields := strings.Fields(template)
+	if !slices.Contains(fields, "{exec}") {
+		return fmt.Errorf("wrapper has to be a preset or contain {exec}: %s", wrapper)
+	}
+	if fields[0] == "{exec}" {
+		return errors.New("wrapper has to start with a command before {exec}")
+	}
+	if strings.Contains(template, "{container}") && container == "" {
+		return fmt.Errorf("wrapper %s needs a container", wrapper)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package util
+
+// don't use any packages from this repo or otherwise not in the stdlib
+// this gets included in the client so it needs to be as small as possible
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoExtensionArgument = errors.New("browser did not pass an extension")
+
+type BrowserFlavour string
+
+const (
+	FirefoxFlavour  BrowserFlavour = "firefox"
+	ChromiumFlavour BrowserFlavour = "chromium"
+)
+
+// BrowserArguments are the arguments a browser passes to a native host.
+type BrowserArguments struct {
+	Flavour      BrowserFlavour
+	Extension    string
+	ManifestPath string
+	// Args are the arguments in the order the browser passed them, without DroppedArgs.
+	Args []string
+	// DroppedArgs are the flags that are not in allowedFlags, they never reach the host.
+	DroppedArgs []string
+}
+
+// allowedFlags are the flags browsers pass that are passed on to the host, with a check of their value.
+// The host runs outside the sandbox, so any other flag is dropped instead of letting the sandbox control the host with it.
+var allowedFlags = map[string]func(value string) bool{
+	// chromium passes the window handle of the browser
+	parentWindowFlag: isNumber,
+}
+
+const parentWindowFlag = "--parent-window"
+
+// ParseBrowserArguments understands the calling conventions of firefox and chromium based browsers.
+// Firefox passes the path of the manifest and the extension ID,
+// chromium passes the origin of the extension and optionally --parent-window=.
+// Flags that are not in allowedFlags are moved to DroppedArgs.
+func ParseBrowserArguments(args []string) (BrowserArguments, error) {
+	parsed := BrowserArguments{}
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name, value, _ := strings.Cut(arg, "=")
+			isValid, allowed := allowedFlags[name]
+			if !allowed || !isValid(value) {
+				parsed.DroppedArgs = append(parsed.DroppedArgs, arg)
+				continue
+			}
+		case strings.HasPrefix(arg, "chrome-extension://"):
+			if parsed.Extension != "" {
+				return parsed, fmt.Errorf("unexpected argument %q", arg)
+			}
+			parsed.Flavour = ChromiumFlavour
+			parsed.Extension = arg
+		case parsed.ManifestPath == "" && filepath.IsAbs(arg) && filepath.Ext(arg) == ".json":
+			parsed.ManifestPath = arg
+		case parsed.Extension == "":
+			parsed.Flavour = FirefoxFlavour
+			parsed.Extension = arg
+		default:
+			return parsed, fmt.Errorf("unexpected argument %q", arg)
+		}
+		parsed.Args = append(parsed.Args, arg)
+	}
+
+	if parsed.Extension == "" {
+		return parsed, ErrNoExtensionArgument
+	}
+	if parsed.Flavour == ChromiumFlavour && parsed.ManifestPath != "" {
+		return parsed, fmt.Errorf("unexpected argument %q", parsed.ManifestPath)
+	}
+
+	return parsed, nil
+}
+
+// HostArguments returns the arguments the browser passed without the dropped ones,
+// the manifest path is replaced with the given one.
+func (arguments *BrowserArguments) HostArguments(manifestPath string) []string {
+	args := []string{}
+	for _, arg := range arguments.Args {
+		if arguments.ManifestPath != "" && arg == arguments.ManifestPath {
+			arg = manifestPath
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// ArgumentsWithoutFlags returns the arguments every launch for the extension gets, in the order the browser passes them.
+func (arguments *BrowserArguments) ArgumentsWithoutFlags(manifestPath string) []string {
+	if arguments.Flavour == ChromiumFlavour {
+		return []string{arguments.Extension}
+	}
+	return []string{manifestPath, arguments.Extension}
+}
+
+func isNumber(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -50,6 +50,17 @@ func (browser *Browser) GetName() string {
 	}
 }
 
+func (browser *Browser) GetFlavour() BrowserFlavour {
+	switch *browser {
+	case Firefox, Floorp:
+		return FirefoxFlavour
+	case Chromium, Brave:
+		return ChromiumFlavour
+	default:
+		panic(ErrBrowserNotKnown)
+	}
+}
+
 func (browser *Browser) GetFlatpakRuntimeAppFolder() string {
 	return filepath.Join(runtimeDir, "app", browser.GetFlatpakId(), shortAppId)
 }
@@ -58,6 +69,57 @@ func (browser *Browser) GetClientPath() string {
 	return filepath.Join(browser.GetFlatpakRuntimeAppFolder(), "client")
 }
 
+func (browser *Browser) GetSocketFolder() string {
+	return GetSocketFolder(browser.GetFlatpakRuntimeAppFolder())
+}
+
+// GetHostClientPath returns the path the browser calls for a native host.
+// It links to the client so the client can tell from its name which host was requested.
+func (browser *Browser) GetHostClientPath(hostName string) string {
+	return GetHostClientPath(browser.GetFlatpakRuntimeAppFolder(), hostName)
+}
+
+func (browser *Browser) GetClientLogPath() string {
+	return GetClientLogPath(browser.GetFlatpakRuntimeAppFolder())
+}
+
+func (browser *Browser) GetRotatedClientLogPath() string {
+	return GetRotatedClientLogPath(browser.GetFlatpakRuntimeAppFolder())
+}
+
+func GetClientLogPath(runtimeAppFolder string) string {
+	return filepath.Join(runtimeAppFolder, "client.log")
+}
+
+func GetRotatedClientLogPath(runtimeAppFolder string) string {
+	return GetClientLogPath(runtimeAppFolder) + ".1"
+}
+
+// MaxClientLogSize is the size after which the client starts a new log file.
+const MaxClientLogSize = 256 * 1024
+
+func GetSocketFolder(runtimeAppFolder string) string {
+	return filepath.Join(runtimeAppFolder, "sockets")
+}
+
+func GetHostsFolder(runtimeAppFolder string) string {
+	return filepath.Join(runtimeAppFolder, hostsFolderName)
+}
+
+func GetHostClientPath(runtimeAppFolder string, hostName string) string {
+	return filepath.Join(GetHostsFolder(runtimeAppFolder), hostName)
+}
+
+// GetHostNameFromClientPath returns the host name if the path was created with GetHostClientPath.
+func GetHostNameFromClientPath(path string) (string, bool) {
+	if filepath.Base(filepath.Dir(path)) != hostsFolderName {
+		return "", false
+	}
+	return filepath.Base(path), true
+}
+
+const hostsFolderName = "hosts"
+
 func GetAllBrowsers() []Browser {
 	return allBrowsers
 }
@@ -3,6 +3,7 @@ package util
 // don't use any packages from this repo or otherwise not in the stdlib
 // this gets included in the client so it needs to be as small as possible
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"os"
@@ -11,21 +12,37 @@ import (
 	"strings"
 )
 
-func GenerateSocketFileName(extensionName string) string {
-	extensionName = strings.TrimPrefix(extensionName, "chrome-extension://")
-	socketNameEncoded := socketEncoding.EncodeToString([]byte(extensionName))
+// GenerateSocketFileName returns the name of the socket for a native host and extension.
+// The browser is already part of the socket folder, so together the name is unique per browser, host and extension.
+func GenerateSocketFileName(hostName string, extensionName string) string {
+	hash := sha256.New()
+	hash.Write([]byte(hostName))
+	hash.Write([]byte{0})
+	hash.Write([]byte(NormalizeExtensionName(extensionName)))
 
-	if len(socketNameEncoded) >= 50 {
-		socketNameEncoded = socketNameEncoded[:50]
-	}
+	return socketEncoding.EncodeToString(hash.Sum(nil))
+}
 
-	return socketNameEncoded
+// NormalizeExtensionName strips the parts of an extension ID or origin that browsers don't pass consistently.
+func NormalizeExtensionName(extensionName string) string {
+	extensionName = strings.TrimPrefix(extensionName, "chrome-extension://")
+	return strings.TrimSuffix(extensionName, "/")
 }
 
 func GetHomeDirPath() string {
 	return homeDir
 }
 
+// GetUserDataDir returns XDG_DATA_HOME, not the data folder of browser-glue.
+func GetUserDataDir() string {
+	return userDataDir
+}
+
+// GetCustomUserDataDir returns the data folder of browser-glue.
+func GetCustomUserDataDir() string {
+	return customUserDataDir
+}
+
 func GetCustomUserConfigDir() string {
 	return customUserConfigDir
 }
@@ -34,6 +51,16 @@ func GetCustomUserCacheDir() string {
 	return customUserCacheDir
 }
 
+// GetCustomUserStateDir returns the folder of browser-glue in XDG_STATE_HOME.
+func GetCustomUserStateDir() string {
+	return customUserStateDir
+}
+
+// GetCustomRuntimeDir returns the folder of browser-glue in XDG_RUNTIME_DIR, it is not visible to the browsers.
+func GetCustomRuntimeDir() string {
+	return customRuntimeDir
+}
+
 func MakePathHomeRelative(path string) string {
 	pathRel, err := filepath.Rel(homeDir, path)
 	if err != nil {
@@ -81,6 +108,14 @@ func findUserCacheDir() string {
 	return cacheDir
 }
 
+func findUserStateDir() string {
+	stateDir, ok := os.LookupEnv("XDG_STATE_HOME")
+	if !ok {
+		stateDir = filepath.Join(homeDir, ".local", "state")
+	}
+	return stateDir
+}
+
 func findRuntimeDir() string {
 	runtimeDir, ok := os.LookupEnv("XDG_RUNTIME_DIR")
 	if !ok {
@@ -106,9 +141,12 @@ var (
 var (
 	homeDir             string = findHomeDirPath()
 	runtimeDir          string = findRuntimeDir()
-	customUserDataDir   string = filepath.Join(findUserDataDirPath(), shortAppId)
+	userDataDir         string = findUserDataDirPath()
+	customUserDataDir   string = filepath.Join(userDataDir, shortAppId)
 	customUserConfigDir string = filepath.Join(findUserConfigDir(), shortAppId)
 	customUserCacheDir  string = filepath.Join(findUserCacheDir(), shortAppId)
+	customUserStateDir  string = filepath.Join(findUserStateDir(), shortAppId)
+	customRuntimeDir    string = filepath.Join(runtimeDir, shortAppId)
 )
 
-var socketEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+-")
+var socketEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+-").WithPadding(base64.NoPadding)
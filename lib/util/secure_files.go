@@ -0,0 +1,198 @@
+package util
+
+// don't use any packages from this repo or otherwise not in the stdlib
+// this gets included in the client so it needs to be as small as possible
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrTampered is returned when a path browser-glue uses is not in the state it left it in.
+var ErrTampered = errors.New("refusing to continue, the environment might have been tampered with")
+
+// MkdirSecure creates a directory and its missing parents.
+// Every folder below the trusted folder containing path must not be a symlink and must belong to the current user,
+// otherwise a sandboxed browser could redirect it. Permissions of path beyond perm are removed.
+func MkdirSecure(path string, perm fs.FileMode) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	base := trustedFolder(path)
+	relPath, err := filepath.Rel(base, path)
+	if err != nil {
+		return err
+	}
+
+	// created one level at a time, so nothing is created through a symlink that was planted in between
+	dir := base
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		err = os.Mkdir(dir, perm)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		err = checkSecureDir(dir, dir != path)
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&^perm != 0 {
+		err = os.Chmod(path, info.Mode().Perm()&perm)
+		if err != nil {
+			return fmt.Errorf("can't restrict permissions of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// trustedFolder returns the closest existing folder above path that only the user or the system can change,
+// like the home or runtime folder. Paths outside of them are checked from the root.
+func trustedFolder(path string) string {
+	trusted := string(filepath.Separator)
+	folders := []string{homeDir, runtimeDir, userDataDir, filepath.Dir(customUserConfigDir), filepath.Dir(customUserCacheDir), filepath.Dir(customUserStateDir)}
+	for _, folder := range folders {
+		if !strings.HasPrefix(path, folder+string(filepath.Separator)) || len(folder) <= len(trusted) {
+			continue
+		}
+		// a missing folder like ~/.local/state is created and checked from the folder above it
+		_, err := os.Stat(folder)
+		if err == nil {
+			trusted = folder
+		}
+	}
+	return trusted
+}
+
+// checkSecureDir also accepts parents of root, the user can't change them either.
+func checkSecureDir(path string, isParent bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("directory %s is a symbolic link: %w", path, ErrTampered)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory: %w", path, ErrTampered)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if isParent && ok && stat.Uid == 0 {
+		return nil
+	}
+	return checkOwner(path, info)
+}
+
+// CheckReplaceable returns ErrTampered if path exists and is not a regular file of the current user.
+// Symlinks are never replaced, because they could point anywhere.
+func CheckReplaceable(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symbolic link: %w", path, ErrTampered)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file: %w", path, ErrTampered)
+	}
+	return checkOwner(path, info)
+}
+
+// CreateExclusive creates a new file, it fails if anything exists at path and never follows symlinks.
+func CreateExclusive(path string, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, perm)
+}
+
+// OpenAppend opens a file for appending and creates it if needed, symlinks are not followed.
+func OpenAppend(path string, perm fs.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		err = checkOwner(path, info)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// ReplaceFile writes data to a new file next to path and moves it into place.
+// An existing path has to pass CheckReplaceable.
+func ReplaceFile(path string, data []byte, perm fs.FileMode) error {
+	err := CheckReplaceable(path)
+	if err != nil {
+		return err
+	}
+
+	// CreateTemp uses O_EXCL, so a file planted under the temporary name is never used
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = file.Chmod(perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// RemoveSocket removes a socket left behind at path, anything else at path is an error.
+func RemoveSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket: %w", path, ErrTampered)
+	}
+	err = checkOwner(path, info)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func checkOwner(path string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s belongs to user %d instead of %d: %w", path, stat.Uid, os.Getuid(), ErrTampered)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package util
+
+// don't use any packages from this repo or otherwise not in the stdlib
+// this gets included in the client so it needs to be as small as possible
+import (
+	"runtime/debug"
+)
+
+// version can be set at build time with -ldflags "-X github.com/taukakao/browser-glue/lib/util.version=..."
+var version string
+
+func GetVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	return VersionFromBuildInfo(info)
+}
+
+// VersionFromBuildInfo also works for the build info of other executables, like the embedded client.
+func VersionFromBuildInfo(info *debug.BuildInfo) string {
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "devel-" + setting.Value[:12]
+		}
+	}
+	return "devel"
+}